@@ -0,0 +1,66 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+)
+
+// alertingLabels are the internal properties kept as labels of alerting series.
+// Other internal properties, e.g. sf_key or sf_resolutionMs, don't identify a time series.
+var alertingLabels = map[string]bool{
+	"sf_metric":            true,
+	"sf_originatingMetric": true,
+	"sf_streamLabel":       true,
+}
+
+// alertingSeries converts the series for alert rules and recording rules: labels are plain strings,
+// names are derived from the metric and labels, and only non-null points of the time range are kept,
+// so that reduce, threshold and math expressions see the same series on every evaluation.
+func alertingSeries(target Target, series []*datasource.TimeSeries) []*datasource.TimeSeries {
+	start := target.StartTime.UnixNano() / int64(time.Millisecond)
+	stop := target.StopTime.UnixNano() / int64(time.Millisecond)
+	result := make([]*datasource.TimeSeries, 0, len(series))
+	for _, s := range series {
+		labels := make(map[string]string)
+		for k, v := range s.Tags {
+			if strings.HasPrefix(k, "sf_") && !alertingLabels[k] {
+				continue
+			}
+			if value := decodeTagValue(v); value != "" {
+				labels[k] = value
+			}
+		}
+		result = append(result, &datasource.TimeSeries{
+			Name:   alertingSeriesName(s.Name, labels),
+			Tags:   labels,
+			Points: pointsInRange(s.Points, start, stop),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// alertingSeriesName returns the metric followed by the sorted labels, e.g. cpu.utilization{host=a, sf_streamLabel=A}
+func alertingSeriesName(metric string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "sf_metric" && k != "sf_originatingMetric" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return metric
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return metric + "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,164 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/signalfx/signalfx-go/idtool"
+	"github.com/signalfx/signalfx-go/signalflow"
+	"github.com/signalfx/signalfx-go/signalflow/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
+)
+
+func TestAlertingSeries(t *testing.T) {
+	// Given
+	target := Target{
+		StartTime: time.Unix(0, 1560762000000*int64(time.Millisecond)),
+		StopTime:  time.Unix(0, 1560762060000*int64(time.Millisecond)),
+		Alerting:  true,
+	}
+	series := []*datasource.TimeSeries{
+		{
+			Name: "cpu.utilization",
+			Tags: map[string]string{"host": "\"b\"", "sf_metric": "\"cpu.utilization\"", "sf_streamLabel": "\"A\"", "sf_key": "[\"host\",\"sf_metric\"]", "sf_resolutionMs": "10000"},
+			Points: []*datasource.Point{
+				{Timestamp: 1560761990000, Value: 99},
+				{Timestamp: 1560762000000, Value: 20},
+				{Timestamp: 1560762030000, Value: 60},
+				{Timestamp: 1560762060000, Value: math.NaN()},
+			},
+		},
+		{
+			Name: "cpu.utilization",
+			Tags: map[string]string{"host": "\"a\"", "sf_metric": "\"cpu.utilization\"", "sf_streamLabel": "\"A\"", "sf_key": "[\"host\",\"sf_metric\"]", "sf_resolutionMs": "10000"},
+			Points: []*datasource.Point{
+				{Timestamp: 1560761990000, Value: 0},
+				{Timestamp: 1560762000000, Value: 80},
+				{Timestamp: 1560762030000, Value: 90},
+			},
+		},
+	}
+	// When
+	alerting := alertingSeries(target, series)
+	// Then
+	assert.Equal(t, 2, len(alerting))
+	assert.Equal(t, map[string]string{"host": "a", "sf_metric": "cpu.utilization", "sf_streamLabel": "A"}, alerting[0].Tags)
+	assert.Equal(t, map[string]string{"host": "b", "sf_metric": "cpu.utilization", "sf_streamLabel": "A"}, alerting[1].Tags)
+	assert.Equal(t, []*datasource.Point{{Timestamp: 1560762000000, Value: 80}, {Timestamp: 1560762030000, Value: 90}}, alerting[0].Points)
+	assert.Equal(t, []*datasource.Point{{Timestamp: 1560762000000, Value: 20}, {Timestamp: 1560762030000, Value: 60}}, alerting[1].Points)
+}
+
+func TestAlertingSeriesNamesAreStable(t *testing.T) {
+	// Given
+	target := Target{Alerting: true}
+	series := []*datasource.TimeSeries{
+		{Name: "cpu.utilization", Tags: map[string]string{"host": "\"b\"", "sf_streamLabel": "\"A\""}},
+		{Name: "cpu.utilization", Tags: map[string]string{"host": "\"a\"", "sf_streamLabel": "\"A\""}},
+	}
+	// When
+	first := alertingSeries(target, series)
+	second := alertingSeries(target, []*datasource.TimeSeries{series[1], series[0]})
+	// Then
+	assert.Equal(t, "cpu.utilization{host=a, sf_streamLabel=A}", first[0].Name)
+	assert.Equal(t, "cpu.utilization{host=b, sf_streamLabel=A}", first[1].Name)
+	assert.Equal(t, first, second)
+}
+
+func TestGetDatapointsAlertingSeries(t *testing.T) {
+	// Given
+	metadata := map[idtool.ID]*messages.MetadataProperties{}
+	for tsid, host := range map[idtool.ID]string{1: "b", 2: "a"} {
+		metadata[tsid] = &messages.MetadataProperties{
+			Metric:             "cpu.utilization",
+			CustomProperties:   map[string]string{"host": host},
+			InternalProperties: map[string]interface{}{"sf_metric": "cpu.utilization", "sf_streamLabel": "A", "sf_key": []string{"host", "sf_metric"}},
+		}
+	}
+	// The second computation sends the time series in reverse order
+	client := new(signalflowClientMock)
+	for _, reversed := range []bool{false, true} {
+		dataMessages := []*messages.DataMessage{
+			{TimestampMillis: 1560761990000, Payloads: []messages.DataPayload{doublePayload(1, 99), doublePayload(2, 0)}},
+			{TimestampMillis: 1560762000000, Payloads: []messages.DataPayload{doublePayload(1, 20), doublePayload(2, 80)}},
+			{TimestampMillis: 1560762030000, Payloads: []messages.DataPayload{doublePayload(1, 60), doublePayload(2, 90)}},
+			{TimestampMillis: 1560762060000, Payloads: []messages.DataPayload{doublePayload(1, math.NaN())}},
+		}
+		if reversed {
+			for _, m := range dataMessages {
+				for i, j := 0, len(m.Payloads)-1; i < j; i, j = i+1, j-1 {
+					m.Payloads[i], m.Payloads[j] = m.Payloads[j], m.Payloads[i]
+				}
+			}
+		}
+		data := make(chan *messages.DataMessage)
+		done := make(chan struct{})
+		computation := new(signalflowComputationMock)
+		computation.On("Done").Return(modifyDone(done))
+		computation.On("Data").Return(modifyData(data))
+		computation.On("Info").Return((<-chan *messages.InfoMessage)(nil))
+		computation.On("Expirations").Return((<-chan *messages.ExpiredTSIDMessage)(nil))
+		computation.On("MaxDelay").Return(time.Duration(0))
+		computation.On("Handle").Return("job")
+		computation.On("Resolution").Return(10 * time.Second)
+		computation.On("IsFinished").Return(true)
+		computation.On("Err").Return(nil)
+		computation.On("Stop").Return(nil)
+		computation.On("TSIDMetadata", idtool.ID(1)).Return(metadata[1])
+		computation.On("TSIDMetadata", idtool.ID(2)).Return(metadata[2])
+		client.On("Execute", mock.Anything).Return(computation, nil).Once()
+		go func() {
+			for _, m := range dataMessages {
+				data <- m
+			}
+			close(done)
+		}()
+	}
+	ds := NewSignalFxDatasource()
+	info := &datasource.DatasourceInfo{
+		Id:                      1,
+		OrgId:                   1,
+		Name:                    "ds1",
+		Url:                     "https://api.signalfx.com",
+		DecryptedSecureJsonData: map[string]string{"accessToken": "token"},
+	}
+	ds.url, _ = ds.buildSignalflowURL(info)
+	ds.token = "token"
+	ds.client = client
+	req := &datasource.DatasourceRequest{
+		Datasource: info,
+		TimeRange:  &datasource.TimeRange{FromEpochMs: 1560762000000, ToEpochMs: 1560762060000},
+		Queries: []*datasource.Query{{
+			RefId:     "A",
+			ModelJson: `{"refId": "A", "program": "data('cpu.utilization').publish()", "format": "time_series", "alerting": true}`,
+		}},
+	}
+	// When
+	response, err := ds.getDatapoints(context.Background(), req)
+	reversed, reversedErr := ds.getDatapoints(context.Background(), req)
+	// Then
+	assert.Nil(t, err)
+	assert.Nil(t, reversedErr)
+	assert.Equal(t, 1, len(response.Results))
+	series := response.Results[0].Series
+	assert.Equal(t, 2, len(series))
+	assert.Equal(t, "cpu.utilization{host=a, sf_streamLabel=A}", series[0].Name)
+	assert.Equal(t, map[string]string{"host": "a", "sf_metric": "cpu.utilization", "sf_streamLabel": "A"}, series[0].Tags)
+	assert.Equal(t, []*datasource.Point{{Timestamp: 1560762000000, Value: 80}, {Timestamp: 1560762030000, Value: 90}}, series[0].Points)
+	assert.Equal(t, "cpu.utilization{host=b, sf_streamLabel=A}", series[1].Name)
+	assert.Equal(t, map[string]string{"host": "b", "sf_metric": "cpu.utilization", "sf_streamLabel": "A"}, series[1].Tags)
+	assert.Equal(t, []*datasource.Point{{Timestamp: 1560762000000, Value: 20}, {Timestamp: 1560762030000, Value: 60}}, series[1].Points)
+	assert.Equal(t, series, reversed.Results[0].Series)
+	request := client.Calls[0].Arguments.Get(0).(*signalflow.ExecuteRequest)
+	assert.Equal(t, time.Unix(0, 1560762060000*int64(time.Millisecond)), request.Stop)
+	assert.True(t, request.Immediate)
+}
+
+func TestAlertingSeriesNameWithoutLabels(t *testing.T) {
+	// Then
+	assert.Equal(t, "cpu.utilization", alertingSeriesName("cpu.utilization", map[string]string{"sf_metric": "cpu.utilization"}))
+}
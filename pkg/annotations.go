@@ -0,0 +1,348 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/signalfx/signalfx-go/signalflow"
+	"github.com/signalfx/signalfx-go/signalflow/messages"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+const annotationsLimit = 1000
+
+// incidentsMaxResults bounds the incidents read for annotations, which can't be filtered by time
+const incidentsMaxResults = 10000
+
+// annotationsTimeout bounds the time spent reading events from a SignalFlow computation
+const annotationsTimeout = 30 * time.Second
+
+type SignalflowEventComputation interface {
+	Events() <-chan *messages.EventMessage
+	Done() <-chan struct{}
+	Err() error
+	Stop() error
+}
+
+// AnnotationQuery marks annotation queries. They either call the events or incidents API
+// or run an events() or alerts() SignalFlow program.
+type AnnotationQuery struct {
+	Annotation bool   `json:"annotation"`
+	Program    string `json:"program"`
+}
+
+type EventResponseItem struct {
+	ID         string                 `json:"id"`
+	EventType  string                 `json:"eventType"`
+	Category   string                 `json:"category"`
+	Dimensions map[string]interface{} `json:"dimensions"`
+	Properties map[string]interface{} `json:"properties"`
+	Timestamp  int64                  `json:"timestamp"`
+}
+
+type IncidentEvent struct {
+	Timestamp    int64  `json:"timestamp"`
+	AnomalyState string `json:"anomalyState"`
+}
+
+type IncidentResponseItem struct {
+	IncidentID   string          `json:"incidentId"`
+	DetectorID   string          `json:"detectorId"`
+	DetectorName string          `json:"detectorName"`
+	Severity     string          `json:"severity"`
+	AnomalyState string          `json:"anomalyState"`
+	Active       bool            `json:"active"`
+	Events       []IncidentEvent `json:"events"`
+}
+
+// Annotation is a single row of an annotation query result
+type Annotation struct {
+	Time    int64
+	TimeEnd int64
+	Title   string
+	Text    string
+	Tags    []string
+}
+
+func (t *SignalFxDatasource) getAnnotations(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall, query AnnotationQuery) (*datasource.DatasourceResponse, error) {
+	apiCall.Method = http.MethodGet
+	switch apiCall.Path {
+	case "/v2/event/find":
+		return t.getEventAnnotations(ctx, tsdbReq, apiCall)
+	case "/v2/incident":
+		return t.getIncidentAnnotations(ctx, tsdbReq, apiCall)
+	}
+	return t.getSignalflowAnnotations(ctx, tsdbReq, query)
+}
+
+func (t *SignalFxDatasource) getEventAnnotations(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
+	params, err := url.ParseQuery(apiCall.Query)
+	if err != nil {
+		return nil, err
+	}
+	params.Set("startTime", fmt.Sprint(tsdbReq.TimeRange.FromEpochMs))
+	params.Set("endTime", fmt.Sprint(tsdbReq.TimeRange.ToEpochMs))
+	if params.Get("limit") == "" {
+		params.Set("limit", fmt.Sprint(annotationsLimit))
+	}
+	apiCall.Query = params.Encode()
+	response := make([]EventResponseItem, 0)
+	if err := t.makeAPICall(ctx, tsdbReq, apiCall, &response); err != nil {
+		return nil, err
+	}
+	annotations := make([]Annotation, 0)
+	for _, e := range response {
+		annotations = append(annotations, eventAnnotation(e))
+	}
+	return t.formatAsAnnotations(queryRefID(tsdbReq), annotations), nil
+}
+
+func (t *SignalFxDatasource) getIncidentAnnotations(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
+	params, err := url.ParseQuery(apiCall.Query)
+	if err != nil {
+		return nil, err
+	}
+	detectorName := params.Get("detectorName")
+	params.Del("detectorName")
+	params.Set("includeResolved", "true")
+	apiCall.Query = params.Encode()
+	incidents, err := t.listIncidents(ctx, tsdbReq, apiCall, annotationsLimit)
+	if err != nil {
+		return nil, err
+	}
+	annotations := make([]Annotation, 0)
+	for _, i := range incidents {
+		if detectorName != "" && !strings.Contains(strings.ToLower(i.DetectorName), strings.ToLower(detectorName)) {
+			continue
+		}
+		a, ok := incidentAnnotation(i)
+		// Incidents are not filtered by time on the SignalFx side
+		if !ok || a.Time > tsdbReq.TimeRange.ToEpochMs || (a.TimeEnd != 0 && a.TimeEnd < tsdbReq.TimeRange.FromEpochMs) {
+			continue
+		}
+		annotations = append(annotations, a)
+	}
+	return t.formatAsAnnotations(queryRefID(tsdbReq), annotations), nil
+}
+
+// listIncidents fetches the incidents page by page, up to incidentsMaxResults
+func (t *SignalFxDatasource) listIncidents(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall, pageSize int) ([]IncidentResponseItem, error) {
+	params, err := url.ParseQuery(apiCall.Query)
+	if err != nil {
+		return nil, err
+	}
+	incidents := make([]IncidentResponseItem, 0)
+	for offset := 0; offset < incidentsMaxResults; {
+		params.Set("limit", fmt.Sprint(pageSize))
+		params.Set("offset", fmt.Sprint(offset))
+		apiCall.Query = params.Encode()
+		response := make([]IncidentResponseItem, 0)
+		if err := t.makeAPICall(ctx, tsdbReq, apiCall, &response); err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, response...)
+		offset += len(response)
+		if len(response) < pageSize {
+			break
+		}
+	}
+	return incidents, nil
+}
+
+func (t *SignalFxDatasource) getSignalflowAnnotations(ctx context.Context, tsdbReq *datasource.DatasourceRequest, query AnnotationQuery) (*datasource.DatasourceResponse, error) {
+	err := t.createSignalflowClient(tsdbReq.Datasource)
+	if err != nil {
+		t.logger.Error("Could not create SignalFlow client", "error", err)
+		return nil, err
+	}
+	dsInfo, err := t.getDsInfo(tsdbReq.Datasource)
+	if err != nil {
+		return nil, err
+	}
+	release, err := t.acquireTransientJob(ctx, dsInfo.jobSettings())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	client := t.signalflowClient()
+
+	_, span := startSpan(ctx, "SignalFlow events", trace.SpanKindClient)
+	defer span.End()
+	span.SetAttributes(attribute.String("program", query.Program))
+	// Annotations are always read from a bounded computation which is not shared with other queries
+	comp, err := client.Execute(&signalflow.ExecuteRequest{
+		Program:   query.Program,
+		Start:     time.Unix(0, tsdbReq.TimeRange.FromEpochMs*int64(time.Millisecond)),
+		Stop:      time.Unix(0, tsdbReq.TimeRange.ToEpochMs*int64(time.Millisecond)),
+		Immediate: true,
+	})
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	events, ok := comp.(SignalflowEventComputation)
+	if !ok {
+		comp.Stop()
+		return nil, errors.New("SignalFlow computation doesn't return events")
+	}
+	annotations, err := readEventAnnotations(ctx, events, annotationsTimeout)
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("annotations", len(annotations)))
+	return t.formatAsAnnotations(queryRefID(tsdbReq), annotations), nil
+}
+
+// readEventAnnotations collects the events of the computation until it finishes
+func readEventAnnotations(ctx context.Context, comp SignalflowEventComputation, timeout time.Duration) ([]Annotation, error) {
+	defer comp.Stop()
+	annotations := make([]Annotation, 0)
+	events := comp.Events()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case em, ok := <-events:
+			if !ok {
+				events = nil
+			} else if em != nil {
+				annotations = append(annotations, signalflowEventAnnotation(em))
+			}
+		case <-comp.Done():
+			// Pick up the events which were delivered together with the end of the computation
+			for events != nil {
+				select {
+				case em, ok := <-events:
+					if !ok {
+						events = nil
+					} else if em != nil {
+						annotations = append(annotations, signalflowEventAnnotation(em))
+					}
+				default:
+					events = nil
+				}
+			}
+			return annotations, comp.Err()
+		case <-timer.C:
+			return nil, errors.New("Timed out reading SignalFlow events")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// signalflowEventAnnotation builds an annotation from an event published by an events() or alerts() program
+func signalflowEventAnnotation(em *messages.EventMessage) Annotation {
+	title := ""
+	for _, k := range []string{"sf_eventType", "sf_detector"} {
+		if v, ok := em.Metadata[k]; ok {
+			title = fmt.Sprint(v)
+			break
+		}
+	}
+	text := make([]string, 0)
+	for _, k := range sortedKeys(em.Properties) {
+		text = append(text, fmt.Sprintf("%s: %s", k, formatEventValue(em.Properties[k])))
+	}
+	tags := make([]string, 0)
+	for _, k := range sortedKeys(em.Metadata) {
+		if !strings.HasPrefix(k, "sf_") {
+			tags = append(tags, fmt.Sprintf("%s:%v", k, em.Metadata[k]))
+		}
+	}
+	return Annotation{
+		Time:  int64(em.TimestampMillis),
+		Title: title,
+		Text:  strings.Join(text, "\n"),
+		Tags:  tags,
+	}
+}
+
+func formatEventValue(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(v)
+		if err == nil {
+			return string(encoded)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+func eventAnnotation(e EventResponseItem) Annotation {
+	text := make([]string, 0)
+	if description, ok := e.Properties["description"]; ok {
+		text = append(text, fmt.Sprint(description))
+	}
+	for _, k := range sortedKeys(e.Properties) {
+		if k != "description" {
+			text = append(text, fmt.Sprintf("%s: %v", k, e.Properties[k]))
+		}
+	}
+	tags := make([]string, 0)
+	for _, k := range sortedKeys(e.Dimensions) {
+		tags = append(tags, fmt.Sprintf("%s:%v", k, e.Dimensions[k]))
+	}
+	return Annotation{
+		Time:  e.Timestamp,
+		Title: e.EventType,
+		Text:  strings.Join(text, "\n"),
+		Tags:  tags,
+	}
+}
+
+// incidentAnnotation spans the incident from the first anomalous event to the event which cleared it
+func incidentAnnotation(i IncidentResponseItem) (Annotation, bool) {
+	a := Annotation{
+		Title: fmt.Sprintf("%s (%s)", i.DetectorName, i.Severity),
+		Text:  i.AnomalyState,
+		Tags:  []string{"detector:" + i.DetectorName, "severity:" + i.Severity},
+	}
+	for _, e := range i.Events {
+		if e.AnomalyState == "ANOMALOUS" && (a.Time == 0 || e.Timestamp < a.Time) {
+			a.Time = e.Timestamp
+		} else if !i.Active && e.AnomalyState != "ANOMALOUS" && e.Timestamp > a.TimeEnd {
+			a.TimeEnd = e.Timestamp
+		}
+	}
+	return a, a.Time != 0
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (t *SignalFxDatasource) formatAsAnnotations(refID string, annotations []Annotation) *datasource.DatasourceResponse {
+	table := NewTableBuilder(
+		TableColumn{Name: "time", Type: timeColumn},
+		TableColumn{Name: "timeEnd", Type: timeColumn},
+		TableColumn{Name: "title", Type: stringColumn},
+		TableColumn{Name: "text", Type: stringColumn},
+		TableColumn{Name: "tags", Type: stringColumn})
+	for _, a := range annotations {
+		// Tags are a JSON array as they may contain commas themselves
+		tags, err := json.Marshal(a.Tags)
+		if err != nil {
+			t.logger.Error("Could not marshal annotation tags", "tags", a.Tags, "error", err)
+			continue
+		}
+		table.AddRow(a.Time, a.TimeEnd, a.Title, a.Text, string(tags))
+	}
+	return table.Response(refID)
+}
@@ -0,0 +1,123 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/signalfx/signalfx-go/signalflow/messages"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestEventAnnotation(t *testing.T) {
+	// Given
+	event := EventResponseItem{
+		EventType:  "deploy",
+		Dimensions: map[string]interface{}{"service": "api", "env": "prod"},
+		Properties: map[string]interface{}{"version": "1.2.3", "description": "Deploy api"},
+		Timestamp:  1560761879121,
+	}
+	// When
+	annotation := eventAnnotation(event)
+	// Then
+	assert.Equal(t, int64(1560761879121), annotation.Time)
+	assert.Equal(t, "deploy", annotation.Title)
+	assert.Equal(t, "Deploy api\nversion: 1.2.3", annotation.Text)
+	assert.Equal(t, []string{"env:prod", "service:api"}, annotation.Tags)
+}
+
+func TestIncidentAnnotation(t *testing.T) {
+	// Given
+	incident := IncidentResponseItem{
+		DetectorName: "CPU",
+		Severity:     "Critical",
+		AnomalyState: "OK",
+		Events: []IncidentEvent{
+			{Timestamp: 2000, AnomalyState: "ANOMALOUS"},
+			{Timestamp: 5000, AnomalyState: "OK"},
+		},
+	}
+	// When
+	annotation, ok := incidentAnnotation(incident)
+	// Then
+	assert.True(t, ok)
+	assert.Equal(t, int64(2000), annotation.Time)
+	assert.Equal(t, int64(5000), annotation.TimeEnd)
+	assert.Equal(t, "CPU (Critical)", annotation.Title)
+	assert.Equal(t, []string{"detector:CPU", "severity:Critical"}, annotation.Tags)
+}
+
+func TestIncidentAnnotationForActiveIncident(t *testing.T) {
+	// Given
+	incident := IncidentResponseItem{
+		Active: true,
+		Events: []IncidentEvent{{Timestamp: 2000, AnomalyState: "ANOMALOUS"}},
+	}
+	// When
+	annotation, ok := incidentAnnotation(incident)
+	// Then
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), annotation.TimeEnd)
+}
+
+func TestReadEventAnnotations(t *testing.T) {
+	// Given
+	events := make(chan *messages.EventMessage, 2)
+	done := make(chan struct{})
+	comp := &signalflowComputationMock{}
+	comp.On("Events").Return((<-chan *messages.EventMessage)(events))
+	comp.On("Done").Return((<-chan struct{})(done))
+	comp.On("Err").Return(nil)
+	comp.On("Stop").Return(nil)
+	events <- &messages.EventMessage{
+		TimestampMillis: 1000,
+		Metadata:        map[string]interface{}{"sf_detector": "CPU", "host": "a"},
+		Properties:      map[string]interface{}{"is": "anomalous"},
+	}
+	events <- &messages.EventMessage{
+		TimestampMillis: 2000,
+		Metadata:        map[string]interface{}{"sf_detector": "CPU", "host": "a"},
+		Properties:      map[string]interface{}{"is": "ok"},
+	}
+	close(done)
+	// When
+	annotations, err := readEventAnnotations(context.Background(), comp, time.Second)
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(annotations))
+	assert.Equal(t, Annotation{Time: 1000, Title: "CPU", Text: "is: anomalous", Tags: []string{"host:a"}}, annotations[0])
+	comp.AssertCalled(t, "Stop")
+}
+
+func TestFormatAsAnnotations(t *testing.T) {
+	// Given
+	ds := NewSignalFxDatasource()
+	annotations := []Annotation{{Time: 1000, TimeEnd: 2000, Title: "deploy", Text: "text", Tags: []string{"a:b", "c:d,e"}}}
+	// When
+	response := ds.formatAsAnnotations("annotations", annotations)
+	// Then
+	assert.Equal(t, "annotations", response.Results[0].RefId)
+	table := response.Results[0].Tables[0]
+	assert.Equal(t, 5, len(table.Columns))
+	assert.Equal(t, int64(2000), table.Rows[0].Values[1].Int64Value)
+	assert.Equal(t, `["a:b","c:d,e"]`, table.Rows[0].Values[4].StringValue)
+}
+
+func TestListIncidentsPaging(t *testing.T) {
+	// Given
+	server := newAPITestServer(map[string]string{
+		"/v2/incident?includeResolved=true&limit=2&offset=0": `[{"incidentId": "A"}, {"incidentId": "B"}]`,
+		"/v2/incident?includeResolved=true&limit=2&offset=2": `[{"incidentId": "C"}]`,
+	})
+	defer server.Close()
+	ds := NewSignalFxDatasource()
+	req := newAPITestRequest(server.URL, `{"path": "/v2/incident", "annotation": true}`)
+	apiCall := &SignalFxApiCall{Path: "/v2/incident", Query: "includeResolved=true"}
+	// When
+	incidents, err := ds.listIncidents(context.Background(), req, apiCall, 2)
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(incidents))
+	assert.Equal(t, "C", incidents[2].IncidentID)
+}
@@ -12,15 +12,19 @@ import (
 	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
 )
 
 type SignalFxApiCall struct {
-	BaseURL string `json:"-"`
-	Method  string `json:"-"`
-	Token   string `json:"-"`
-	Path    string `json:"path"`
-	Query   string `json:"query"`
-	Data    string `json:"data"`
+	BaseURL    string `json:"-"`
+	Method     string `json:"-"`
+	Token      string `json:"-"`
+	Datasource string `json:"-"`
+	Path       string `json:"path"`
+	Query      string `json:"query"`
+	Data       string `json:"data"`
 }
 
 type SignalFxApiClient struct {
@@ -29,7 +33,10 @@ type SignalFxApiClient struct {
 }
 
 type MetricResponseItem struct {
-	Name string `json:"name"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	LastUpdated int64  `json:"lastUpdated"`
 }
 
 type MetricResponse struct {
@@ -46,7 +53,19 @@ func NewSignalFxApiClient(logger hclog.Logger) *SignalFxApiClient {
 	return client
 }
 
-func (t *SignalFxApiClient) doRequest(apiCall *SignalFxApiCall, response interface{}) error {
+func (t *SignalFxApiClient) doRequest(ctx context.Context, apiCall *SignalFxApiCall, response interface{}) (err error) {
+	start := time.Now()
+	ctx, span := startSpan(ctx, "doRequest", trace.SpanKindClient)
+	span.SetAttributes(attribute.String("http.method", apiCall.Method), attribute.String("path", apiCall.Path))
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+			recordSpanError(span, err)
+		}
+		span.End()
+		apiRequestDurationMetric.WithLabelValues(apiCall.Datasource, apiCall.Path, outcome).Observe(time.Since(start).Seconds())
+	}()
 	u, err := url.Parse(apiCall.BaseURL)
 	if err != nil {
 		t.logger.Error("Error parsing SignalFx API URL", "error", err)
@@ -64,6 +83,7 @@ func (t *SignalFxApiClient) doRequest(apiCall *SignalFxApiCall, response interfa
 		t.logger.Error("Error creating request to SignalFx API", "error", err)
 		return err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("X-SF-TOKEN", apiCall.Token)
 	if apiCall.Method == http.MethodPost {
 		req.Header.Set("Content-Type", "application/json")
@@ -74,6 +94,7 @@ func (t *SignalFxApiClient) doRequest(apiCall *SignalFxApiCall, response interfa
 		return err
 	}
 	defer rsp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", rsp.StatusCode))
 	if rsp.StatusCode/100 != 2 {
 		message, _ := ioutil.ReadAll(rsp.Body)
 		err = fmt.Errorf("Bad status %d: %s", rsp.StatusCode, message)
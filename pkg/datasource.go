@@ -3,6 +3,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"sync"
@@ -12,46 +14,127 @@ import (
 	hclog "github.com/hashicorp/go-hclog"
 	plugin "github.com/hashicorp/go-plugin"
 	"github.com/signalfx/signalfx-go/signalflow"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/context"
 )
 
 type SignalFxDatasource struct {
 	plugin.NetRPCUnsupportedPlugin
-	logger       hclog.Logger
-	handlers     []SignalFxJob
-	client       *signalflow.Client
-	url          string
-	token        string
-	handlerMutex sync.Mutex
-	clientMutex  sync.Mutex
-	apiClient    *SignalFxApiClient
+	logger            hclog.Logger
+	handlers          []SignalFxJob
+	client            SignalflowConnection
+	url               string
+	token             string
+	cleanupIntervals  map[string]time.Duration
+	handlerMutex      sync.Mutex
+	clientMutex       sync.Mutex
+	apiClient         *SignalFxApiClient
+	breakers          map[string]*circuitBreaker
+	maxJobs           int
+	configuredMaxJobs map[string]int
+	slotWaiters       []*jobSlotWaiter
+	transientJobs     map[string]int
+	resultCaches      map[string]*resultCache
+	settingsJSON      map[string]string
+	jobsFlushedAt     map[string]int64
+	done              chan struct{}
+	closing           bool
+	shutdownOnce      sync.Once
 }
 
+// shutdownTimeout bounds the time spent stopping jobs when Grafana stops the plugin. go-plugin
+// kills the plugin process 250ms after asking it to exit.
+const shutdownTimeout = 100 * time.Millisecond
+
+var errShuttingDown = errors.New("SignalFx datasource is shutting down")
+
+// DatasourceInfo holds the datasource settings. Durations are in milliseconds.
 type DatasourceInfo struct {
-	AccessToken string `json:"accessToken"`
+	Name                         string `json:"-"`
+	OrgID                        int64  `json:"-"`
+	ID                           int64  `json:"-"`
+	AccessToken                  string `json:"accessToken"`
+	StreamingThreshold           int64  `json:"streamingThreshold"`
+	InactiveJobTimeout           int64  `json:"inactiveJobTimeout"`
+	PinnedJobTimeout             int64  `json:"pinnedJobTimeout"`
+	MaxDatapointsBeforeTimerange int64  `json:"maxDatapointsBeforeTimerange"`
+	CleanupInterval              int64  `json:"cleanupInterval"`
+	AlertingQueryTimeout         int64  `json:"alertingQueryTimeout"`
+	MaxJobs                      int    `json:"maxJobs"`
+	GlobalMaxJobs                int    `json:"globalMaxJobs"`
+	JobQueueTimeout              int64  `json:"jobQueueTimeout"`
+	QueryTimeout                 int64  `json:"queryTimeout"`
+	JobAdmin                     bool   `json:"jobAdmin"`
+	FlushJobsAt                  int64  `json:"flushJobsAt"`
+	StopJobID                    string `json:"stopJobId"`
+	ResultCacheMaxPoints         int64  `json:"resultCacheMaxPoints"`
+	ResultCacheTTL               int64  `json:"resultCacheTTL"`
 }
 
 type Target struct {
-	RefID         string        `json:"refId"`
-	Program       string        `json:"program"`
-	StartTime     time.Time     `json:"-"`
-	StopTime      time.Time     `json:"-"`
-	Interval      time.Duration `json:"-"`
-	Alias         string        `json:"alias"`
-	MaxDelay      int64         `json:"maxDelay"`
-	MinResolution int64         `json:"minResolution"`
+	RefID           string        `json:"refId"`
+	Program         string        `json:"program"`
+	StartTime       time.Time     `json:"-"`
+	StopTime        time.Time     `json:"-"`
+	Interval        time.Duration `json:"-"`
+	Alias           string        `json:"alias"`
+	MaxDelay        int64         `json:"maxDelay"`
+	MinResolution   int64         `json:"minResolution"`
+	KeepStreaming   bool          `json:"keepStreaming"`
+	Format          string        `json:"format"`
+	Reducer         string        `json:"reducer"`
+	MaxDataPoints   int64         `json:"maxDataPoints"`
+	Downsampling    string        `json:"downsampling"`
+	MaxResolution   int64         `json:"maxResolution"`
+	ExactResolution bool          `json:"exactResolution"`
+	Alerting        bool          `json:"alerting"`
+	Alignment       string        `json:"alignment"`
+	fingerprint     string
+}
+
+// Fingerprint returns the program fingerprint of the target, computing it only once
+func (t *Target) Fingerprint() string {
+	if t.fingerprint == "" {
+		t.fingerprint = programFingerprint(t.Program)
+	}
+	return t.fingerprint
+}
+
+func (d *DatasourceInfo) jobSettings() JobSettings {
+	return JobSettings{
+		Datasource:                         d.Name,
+		OrgID:                              d.OrgID,
+		DatasourceID:                       d.ID,
+		StreamingThresholdTimeout:          time.Duration(d.StreamingThreshold) * time.Millisecond,
+		InactiveJobTimeout:                 time.Duration(d.InactiveJobTimeout) * time.Millisecond,
+		PinnedJobTimeout:                   time.Duration(d.PinnedJobTimeout) * time.Millisecond,
+		MaxDatapointsToKeepBeforeTimerange: d.MaxDatapointsBeforeTimerange,
+		CleanupInterval:                    time.Duration(d.CleanupInterval) * time.Millisecond,
+		AlertingQueryTimeout:               time.Duration(d.AlertingQueryTimeout) * time.Millisecond,
+		MaxJobs:                            d.MaxJobs,
+		GlobalMaxJobs:                      d.GlobalMaxJobs,
+		JobQueueTimeout:                    time.Duration(d.JobQueueTimeout) * time.Millisecond,
+		QueryTimeout:                       time.Duration(d.QueryTimeout) * time.Millisecond,
+		ResultCacheMaxPoints:               d.ResultCacheMaxPoints,
+		ResultCacheTTL:                     time.Duration(d.ResultCacheTTL) * time.Millisecond,
+	}
 }
 
 func NewSignalFxDatasource() *SignalFxDatasource {
 	datasource := &SignalFxDatasource{
-		logger:       pluginLogger,
-		handlers:     make([]SignalFxJob, 0),
-		clientMutex:  sync.Mutex{},
-		handlerMutex: sync.Mutex{},
-		apiClient:    NewSignalFxApiClient(pluginLogger),
-	}
-	tick := time.NewTicker(time.Second * 30)
-	go datasource.cleanup(tick)
+		logger:           pluginLogger,
+		handlers:         make([]SignalFxJob, 0),
+		cleanupIntervals: make(map[string]time.Duration),
+		clientMutex:      sync.Mutex{},
+		handlerMutex:     sync.Mutex{},
+		apiClient:        NewSignalFxApiClient(pluginLogger),
+		breakers:         make(map[string]*circuitBreaker),
+		maxJobs:          maxJobsFromEnv(),
+		transientJobs:    make(map[string]int),
+		done:             make(chan struct{}),
+	}
+	go datasource.cleanup()
 	return datasource
 }
 
@@ -59,98 +142,196 @@ func (t *SignalFxDatasource) Query(ctx context.Context, tsdbReq *datasource.Data
 
 	t.logger.Debug("Running query", "req", tsdbReq)
 
+	if t.isClosing() {
+		return nil, errShuttingDown
+	}
+
+	ctx, span := startSpan(ctx, "Query", trace.SpanKindServer)
+	defer span.End()
+	if tsdbReq.Datasource != nil {
+		span.SetAttributes(attribute.String("datasource", tsdbReq.Datasource.Name))
+	}
+	span.SetAttributes(attribute.Int("queries", len(tsdbReq.Queries)))
+	response, err := t.runQuery(ctx, tsdbReq)
+	if err != nil {
+		recordSpanError(span, err)
+	}
+	return response, err
+}
+
+func (t *SignalFxDatasource) runQuery(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+	if _, err := t.getDsInfo(tsdbReq.Datasource); err != nil {
+		t.logger.Error("Could not parse datasource settings", "error", err)
+		return nil, err
+	}
+
 	var apiCall SignalFxApiCall
 	if err := json.Unmarshal([]byte(tsdbReq.Queries[0].ModelJson), &apiCall); err != nil {
 		t.logger.Error("Could not unmarshal query", "error", err)
 		return nil, err
 	}
 
+	var annotationQuery AnnotationQuery
+	if err := json.Unmarshal([]byte(tsdbReq.Queries[0].ModelJson), &annotationQuery); err == nil && annotationQuery.Annotation {
+		return t.getAnnotations(ctx, tsdbReq, &apiCall, annotationQuery)
+	}
+
 	switch apiCall.Path {
 	case "/v2/metric":
 		apiCall.Method = http.MethodGet
-		return t.getMetrics(tsdbReq, &apiCall)
+		return t.getMetrics(ctx, tsdbReq, &apiCall)
 	case "/v2/suggest/_signalflowsuggest":
 		apiCall.Method = http.MethodPost
-		return t.getSuggestions(tsdbReq, &apiCall)
+		return t.getSuggestions(ctx, tsdbReq, &apiCall)
+	case "/v2/detector":
+		apiCall.Method = http.MethodGet
+		return t.getDetectors(ctx, tsdbReq, &apiCall)
+	case "/v2/incident":
+		apiCall.Method = http.MethodGet
+		return t.getIncidents(ctx, tsdbReq, &apiCall)
+	case "/v2/dimension":
+		apiCall.Method = http.MethodGet
+		return t.getDimensions(ctx, tsdbReq, &apiCall)
+	case jobsPath:
+		return t.getJobs(tsdbReq)
 	}
 
-	return t.getDatapoints(tsdbReq)
+	return t.getDatapoints(ctx, tsdbReq)
 }
 
-func (t *SignalFxDatasource) getMetrics(tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
+func (t *SignalFxDatasource) getMetrics(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
 	response := MetricResponse{}
-	t.makeAPICall(tsdbReq, apiCall, &response)
+	if err := t.makeAPICall(ctx, tsdbReq, apiCall, &response); err != nil {
+		return nil, err
+	}
 	t.logger.Debug("Unmarshalled API response", "response", response)
-	values := make([]string, 0)
+	table := NewTableBuilder(
+		TableColumn{Name: "name", Type: stringColumn},
+		TableColumn{Name: "description", Type: stringColumn},
+		TableColumn{Name: "type", Type: stringColumn},
+		TableColumn{Name: "lastUpdated", Type: timeColumn})
 	for _, r := range response.Results {
-		values = append(values, r.Name)
+		table.AddRow(r.Name, r.Description, r.Type, r.LastUpdated)
 	}
-	return t.formatAsTable(values), nil
+	return table.Response(queryRefID(tsdbReq)), nil
 }
 
-func (t *SignalFxDatasource) getSuggestions(tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
+func (t *SignalFxDatasource) getSuggestions(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
 	response := make([]string, 0)
-	t.makeAPICall(tsdbReq, apiCall, &response)
+	t.makeAPICall(ctx, tsdbReq, apiCall, &response)
 	t.logger.Debug("Unmarshalled API response", "response", response)
-	return t.formatAsTable(response), nil
+	return t.formatAsTable(queryRefID(tsdbReq), response), nil
 }
 
-func (t *SignalFxDatasource) formatAsTable(values []string) *datasource.DatasourceResponse {
-	table := &datasource.Table{
-		Columns: make([]*datasource.TableColumn, 0),
-		Rows:    make([]*datasource.TableRow, 0),
-	}
-	table.Columns = append(table.Columns, &datasource.TableColumn{Name: "name"})
+func (t *SignalFxDatasource) formatAsTable(refID string, values []string) *datasource.DatasourceResponse {
+	table := NewTableBuilder(TableColumn{Name: "name", Type: stringColumn})
 	for _, r := range values {
-		row := &datasource.TableRow{}
-		row.Values = append(row.Values, &datasource.RowValue{Kind: datasource.RowValue_TYPE_STRING, StringValue: r})
-		table.Rows = append(table.Rows, row)
-	}
-	return &datasource.DatasourceResponse{
-		Results: []*datasource.QueryResult{
-			{
-				RefId:  "items",
-				Tables: []*datasource.Table{table},
-			},
-		},
+		table.AddRow(r)
 	}
+	return table.Response(refID)
 }
 
-func (t *SignalFxDatasource) makeAPICall(tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall, response interface{}) error {
+// queryRefID returns the RefID of the single query of REST API requests
+func queryRefID(tsdbReq *datasource.DatasourceRequest) string {
+	if len(tsdbReq.Queries) == 0 {
+		return ""
+	}
+	return tsdbReq.Queries[0].RefId
+}
+
+func (t *SignalFxDatasource) makeAPICall(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall, response interface{}) error {
 	apiCall.BaseURL = tsdbReq.Datasource.Url
+	apiCall.Datasource = tsdbReq.Datasource.Name
 	t.logger.Debug("Making API Call", "call", apiCall)
 	dsInfo, err := t.getDsInfo(tsdbReq.Datasource)
 	if err != nil {
 		return err
 	}
 	apiCall.Token = dsInfo.AccessToken
-	return t.apiClient.doRequest(apiCall, &response)
+	return t.apiClient.doRequest(ctx, apiCall, &response)
 }
 
-func (t *SignalFxDatasource) getDatapoints(tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+func (t *SignalFxDatasource) getDatapoints(ctx context.Context, tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
 	err := t.createSignalflowClient(tsdbReq.Datasource)
 	if err != nil {
 		t.logger.Error("Could not create SignalFlow client", "error", err)
 		return nil, err
 	}
 
-	targets, err := t.buildTargets(tsdbReq)
+	dsInfo, err := t.getDsInfo(tsdbReq.Datasource)
+	if err != nil {
+		t.logger.Error("Could not parse datasource settings", "error", err)
+		return nil, err
+	}
+	settings := dsInfo.jobSettings()
+	t.updateCleanupInterval(settings.Datasource, settings.cleanupInterval())
+	t.updateGlobalMaxJobs(settings.Datasource, settings.GlobalMaxJobs)
+
+	_, buildSpan := startSpan(ctx, "buildTargets", trace.SpanKindInternal)
+	targets, err := t.buildTargets(tsdbReq, settings)
+	buildSpan.End()
 	if err != nil {
 		t.logger.Error("Could not parse queries", "error", err)
 		return nil, err
 	}
 
+	cache := t.getResultCache(tsdbReq.Datasource, dsInfo)
 	response := &datasource.DatasourceResponse{}
 	for _, target := range targets {
-		ch, err := t.startJobHandler(target)
-		if err != nil {
-			t.logger.Error("Could not execute request", "error", err)
-			return nil, err
+		targetCtx, targetSpan := startSpan(ctx, "Target", trace.SpanKindInternal)
+		targetSpan.SetAttributes(
+			attribute.String("refId", target.RefID),
+			attribute.String("program", target.Program),
+			attribute.Bool("alerting", target.Alerting))
+		var r *JobResult
+		cacheKey := ""
+		if cache != nil && !target.Alerting {
+			cacheKey = resultCacheKey(target)
+			if cached, ok := cache.get(cacheKey, time.Now()); ok {
+				resultCacheRequestsMetric.WithLabelValues(settings.Datasource, "hit").Inc()
+				targetSpan.SetAttributes(attribute.Bool("cached", true))
+				r = &JobResult{Series: cached.Series, Meta: cached.Meta}
+				r.Meta.Cached = true
+			} else {
+				resultCacheRequestsMetric.WithLabelValues(settings.Datasource, "miss").Inc()
+			}
 		}
-		s := <-ch
+		if r == nil {
+			if target.Alerting {
+				r, err = t.runAlertingQuery(targetCtx, target, settings)
+			} else {
+				var ch <-chan *JobResult
+				if ch, err = t.startJobHandler(targetCtx, target, settings); err == nil {
+					r = <-ch
+				}
+			}
+			if err != nil {
+				t.logger.Error("Could not execute request", "error", err)
+				recordSpanError(targetSpan, err)
+				targetSpan.End()
+				return nil, err
+			}
+			if cacheKey != "" && isCacheableResult(target, r, settings) {
+				cache.put(cacheKey, r, time.Now())
+			}
+		}
+		targetSpan.SetAttributes(attribute.Int("series", len(r.Series)))
+		targetSpan.End()
 		result := &datasource.QueryResult{
-			RefId:  target.RefID,
-			Series: s,
+			RefId: target.RefID,
+		}
+		if target.Format == formatTimeSeries && target.Alerting {
+			result.Series = alertingSeries(target, r.Series)
+		} else if target.Format == formatTimeSeries {
+			result.Series = r.Series
+		} else {
+			result.Tables = []*datasource.Table{seriesTable(target, r.Series).Table()}
+		}
+		if meta, err := json.Marshal(r.Meta); err == nil {
+			result.MetaJson = string(meta)
+		}
+		if err := checkResolution(target, r.Meta); err != nil {
+			result.Error = err.Error()
 		}
 		response.Results = append(response.Results, result)
 	}
@@ -187,7 +368,7 @@ func (t *SignalFxDatasource) createSignalflowClient(datasource *datasource.Datas
 		if err != nil {
 			return err
 		}
-		t.client = c
+		t.client = signalflowConnection{c}
 		t.url = url
 		t.token = token
 	}
@@ -195,6 +376,16 @@ func (t *SignalFxDatasource) createSignalflowClient(datasource *datasource.Datas
 	return nil
 }
 
+// signalflowClient returns the current SignalFlow client, or nil before it is created
+func (t *SignalFxDatasource) signalflowClient() SignalflowClient {
+	t.clientMutex.Lock()
+	defer t.clientMutex.Unlock()
+	if t.client == nil {
+		return nil
+	}
+	return t.client
+}
+
 func (t *SignalFxDatasource) buildSignalflowURL(datasourceInfo *datasource.DatasourceInfo) (string, error) {
 	sfxURL, err := url.Parse(datasourceInfo.Url)
 	if err != nil {
@@ -209,39 +400,107 @@ func (t *SignalFxDatasource) buildSignalflowURL(datasourceInfo *datasource.Datas
 
 func (t *SignalFxDatasource) getDsInfo(datasourceInfo *datasource.DatasourceInfo) (*DatasourceInfo, error) {
 	var dsInfo DatasourceInfo
-	if val, ok := datasourceInfo.DecryptedSecureJsonData["accessToken"]; ok {
-		dsInfo.AccessToken = val
-	} else {
+	if datasourceInfo.JsonData != "" {
 		if err := json.Unmarshal([]byte(datasourceInfo.JsonData), &dsInfo); err != nil {
 			return nil, err
 		}
 	}
+	dsInfo.Name = datasourceInfo.Name
+	dsInfo.OrgID = datasourceInfo.OrgId
+	dsInfo.ID = datasourceInfo.Id
+	if val, ok := datasourceInfo.DecryptedSecureJsonData["accessToken"]; ok {
+		dsInfo.AccessToken = val
+	}
+	t.applyJobAdminSettings(datasourceInfo, &dsInfo)
 	return &dsInfo, nil
 }
 
-func (t *SignalFxDatasource) startJobHandler(target Target) (<-chan []*datasource.TimeSeries, error) {
+func (t *SignalFxDatasource) startJobHandler(ctx context.Context, target Target, settings JobSettings) (<-chan *JobResult, error) {
 	t.handlerMutex.Lock()
 	defer t.handlerMutex.Unlock()
-	// Try to re-use any existing job if possible
-	for _, h := range t.handlers {
-		ch := h.reuse(&target)
-		if ch != nil {
-			return ch, nil
+	// Try to re-use any existing job if possible, also after waiting for a free job slot
+	reuse := func() <-chan *JobResult {
+		for _, h := range t.handlers {
+			if ch := h.reuse(ctx, &target); ch != nil {
+				trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("reused", true))
+				return ch
+			}
 		}
+		return nil
+	}
+	if ch, err := t.acquireJobSlot(ctx, settings, reuse); ch != nil || err != nil {
+		return ch, err
 	}
 
+	client := t.signalflowClient()
 	handler := &SignalFxJobHandler{
-		logger: t.logger,
-		client: t.client,
+		logger:        t.logger,
+		client:        client,
+		currentClient: t.signalflowClient,
+		settings:      settings,
+		breaker:       t.circuitBreaker(settings.Datasource),
+		jobSlots:      t.acquireTransientJob,
 	}
-	ch, err := handler.start(&target)
+	ch, err := handler.start(ctx, &target)
 	if ch != nil {
 		t.handlers = append(t.handlers, handler)
+		jobRequestsMetric.WithLabelValues(settings.Datasource, "started").Inc()
+	} else {
+		jobRequestsMetric.WithLabelValues(settings.Datasource, "error").Inc()
 	}
+	t.updateJobsMetric()
 	return ch, err
 }
 
-func (t *SignalFxDatasource) buildTargets(tsdbReq *datasource.DatasourceRequest) ([]Target, error) {
+// runAlertingQuery runs the target as a bounded computation which is stopped after the result
+// or the alerting query timeout. It is not registered for re-use, so alert evaluations neither
+// depend on the state of running jobs nor leave jobs behind.
+func (t *SignalFxDatasource) runAlertingQuery(ctx context.Context, target Target, settings JobSettings) (*JobResult, error) {
+	release, err := t.acquireTransientJob(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	client := t.signalflowClient()
+	handler := &SignalFxJobHandler{
+		logger:   t.logger,
+		client:   client,
+		settings: settings,
+	}
+	target.StopTime = cutoffTime(target.StopTime)
+	ch, err := handler.start(ctx, &target)
+	if err != nil {
+		jobRequestsMetric.WithLabelValues(settings.Datasource, "error").Inc()
+		return nil, err
+	}
+	jobRequestsMetric.WithLabelValues(settings.Datasource, "alerting").Inc()
+	defer handler.stop()
+	timeout := time.NewTimer(settings.alertingQueryTimeout())
+	defer timeout.Stop()
+	select {
+	case r := <-ch:
+		return r, nil
+	case <-timeout.C:
+		return nil, fmt.Errorf("SignalFlow computation did not complete within %s", settings.alertingQueryTimeout())
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// updateJobsMetric recomputes the number of cached jobs per datasource. It must be called with handlerMutex held.
+func (t *SignalFxDatasource) updateJobsMetric() {
+	counts := make(map[string]int)
+	for _, h := range t.handlers {
+		counts[h.Datasource()]++
+	}
+	jobsMetric.Reset()
+	for name, count := range counts {
+		jobsMetric.WithLabelValues(name).Set(float64(count))
+	}
+}
+
+func (t *SignalFxDatasource) buildTargets(tsdbReq *datasource.DatasourceRequest, settings JobSettings) ([]Target, error) {
 	startTime := time.Unix(0, tsdbReq.TimeRange.FromEpochMs*int64(time.Millisecond))
 	stopTime := time.Unix(0, tsdbReq.TimeRange.ToEpochMs*int64(time.Millisecond))
 	targets := make([]Target, 0)
@@ -252,24 +511,66 @@ func (t *SignalFxDatasource) buildTargets(tsdbReq *datasource.DatasourceRequest)
 		if err := json.Unmarshal([]byte(query.ModelJson), &target); err != nil {
 			return nil, err
 		}
-		var intervalMs = query.IntervalMs
-		if intervalMs < target.MinResolution {
-			intervalMs = target.MinResolution
+		if err := validateTarget(&target); err != nil {
+			return nil, err
+		}
+		if query.MaxDataPoints > 0 {
+			target.MaxDataPoints = query.MaxDataPoints
+		}
+		if err := resolveInterval(&target, query.IntervalMs, stopTime.Sub(startTime)); err != nil {
+			return nil, err
+		}
+		if target.Format != formatTimeSeries {
+			// Tables reduce the full resolution data instead
+			target.MaxDataPoints = 0
 		}
-		target.Interval = time.Duration(intervalMs) * time.Millisecond
 		target.StartTime = startTime
 		target.StopTime = stopTime
+		target.Fingerprint()
+		alignTimeRange(&target, settings.streamingThresholdTimeout())
 		targets = append(targets, target)
 	}
 	return targets, nil
 }
 
-func (t *SignalFxDatasource) cleanup(ticker *time.Ticker) {
-	for time := range ticker.C {
-		t.cleanupInactiveJobHandlers(time)
+func (t *SignalFxDatasource) cleanup() {
+	for {
+		select {
+		case time := <-time.After(t.getCleanupInterval()):
+			t.cleanupInactiveJobHandlers(time)
+		case <-t.done:
+			return
+		}
 	}
 }
 
+// updateCleanupInterval records the cleanup interval currently configured on the datasource
+func (t *SignalFxDatasource) updateCleanupInterval(datasource string, interval time.Duration) {
+	t.handlerMutex.Lock()
+	defer t.handlerMutex.Unlock()
+	if t.cleanupIntervals == nil {
+		t.cleanupIntervals = make(map[string]time.Duration)
+	}
+	t.cleanupIntervals[datasource] = interval
+}
+
+// getCleanupInterval returns the shortest cleanup interval of the datasources, so that raising
+// the interval of a datasource takes effect once no other datasource uses a shorter one
+func (t *SignalFxDatasource) getCleanupInterval() time.Duration {
+	t.handlerMutex.Lock()
+	defer t.handlerMutex.Unlock()
+	interval := time.Duration(0)
+	for _, i := range t.cleanupIntervals {
+		if interval == 0 || i < interval {
+			interval = i
+		}
+	}
+	if interval == 0 {
+		return defaultCleanupInterval
+	}
+	return interval
+}
+
 func (t *SignalFxDatasource) cleanupInactiveJobHandlers(time time.Time) {
 	t.handlerMutex.Lock()
 	active := make([]SignalFxJob, 0)
@@ -282,5 +583,68 @@ func (t *SignalFxDatasource) cleanupInactiveJobHandlers(time time.Time) {
 		}
 	}
 	t.handlers = active
+	t.updateJobsMetric()
+	t.notifyJobSlotWaiters()
 	t.handlerMutex.Unlock()
 }
+
+// isClosing returns true once the datasource is shutting down
+func (t *SignalFxDatasource) isClosing() bool {
+	t.handlerMutex.Lock()
+	defer t.handlerMutex.Unlock()
+	return t.closing
+}
+
+// Shutdown rejects new queries, stops all jobs in parallel and closes the SignalFlow client.
+// Running queries aren't waited for, and the jobs get at most the timeout to send their stop
+// requests before the client is closed.
+func (t *SignalFxDatasource) Shutdown(timeout time.Duration) {
+	t.shutdownOnce.Do(func() {
+		t.logger.Debug("Shutting down SignalFx datasource")
+
+		t.handlerMutex.Lock()
+		t.closing = true
+		if t.done != nil {
+			close(t.done)
+		}
+		handlers := t.handlers
+		t.handlers = make([]SignalFxJob, 0)
+		t.updateJobsMetric()
+		t.handlerMutex.Unlock()
+
+		var stopped sync.WaitGroup
+		for _, h := range handlers {
+			stopped.Add(1)
+			go func(h SignalFxJob) {
+				defer stopped.Done()
+				t.logger.Debug("Stopping job on shutdown", "program", h.Program())
+				h.stop()
+			}(h)
+		}
+		if !waitTimeout(&stopped, timeout) {
+			t.logger.Warn("Timed out stopping jobs")
+		}
+
+		t.clientMutex.Lock()
+		if t.client != nil {
+			t.client.Close()
+			t.client = nil
+		}
+		t.clientMutex.Unlock()
+	})
+}
+
+// waitTimeout waits for the wait group and returns false if the timeout elapsed first
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
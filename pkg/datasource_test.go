@@ -2,6 +2,11 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"syscall"
 	"testing"
 	"time"
 
@@ -9,6 +14,7 @@ import (
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
 )
 
 var datasourceHandlerTestLogger = hclog.New(&hclog.LoggerOptions{
@@ -29,9 +35,9 @@ func (m *signalflowJob) isActive(time time.Time) bool {
 	return args.Bool(0)
 }
 
-func (m *signalflowJob) reuse(target *Target) <-chan []*datasource.TimeSeries {
+func (m *signalflowJob) reuse(ctx context.Context, target *Target) <-chan *JobResult {
 	args := m.Called()
-	return args.Get(0).(<-chan []*datasource.TimeSeries)
+	return args.Get(0).(<-chan *JobResult)
 }
 
 func (m *signalflowJob) Program() string {
@@ -39,6 +45,41 @@ func (m *signalflowJob) Program() string {
 	return args.String(0)
 }
 
+func (m *signalflowJob) Datasource() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *signalflowJob) Settings() JobSettings {
+	args := m.Called()
+	return args.Get(0).(JobSettings)
+}
+
+func (m *signalflowJob) ID() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *signalflowJob) LastUsed() time.Time {
+	args := m.Called()
+	return args.Get(0).(time.Time)
+}
+
+func (m *signalflowJob) isRunning() bool {
+	args := m.Called()
+	return args.Bool(0)
+}
+
+func (m *signalflowJob) isIdle() bool {
+	args := m.Called()
+	return args.Bool(0)
+}
+
+func (m *signalflowJob) info() JobInfo {
+	args := m.Called()
+	return args.Get(0).(JobInfo)
+}
+
 func TestBuildSignalflowURL(t *testing.T) {
 	// Given
 	ds := &SignalFxDatasource{}
@@ -63,7 +104,7 @@ func TestBuildTargets(t *testing.T) {
 	req.Queries[0].ModelJson = "{\"refId\": \"ref123\", \"program\": \"some program\"}"
 	req.Queries[0].IntervalMs = 1000
 	// When
-	targets, _ := ds.buildTargets(req)
+	targets, _ := ds.buildTargets(req, JobSettings{})
 	// Then
 	assert.NotNil(t, targets)
 	assert.Equal(t, 1, len(targets))
@@ -74,6 +115,40 @@ func TestBuildTargets(t *testing.T) {
 	assert.Equal(t, req.TimeRange.ToEpochMs, targets[0].StopTime.UnixNano()/int64(time.Millisecond))
 }
 
+func TestBuildTargetsWithAlignment(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{}
+	req := &datasource.DatasourceRequest{}
+	req.TimeRange = &datasource.TimeRange{FromEpochMs: 1560761879121, ToEpochMs: 1560762879121}
+	req.Queries = []*datasource.Query{{ModelJson: "{\"refId\": \"A\", \"program\": \"some program\", \"alignment\": \"floor\"}", IntervalMs: 1000}}
+	// When
+	targets, err := ds.buildTargets(req, JobSettings{})
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1560761879000), targets[0].StartTime.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, int64(1560762879000), targets[0].StopTime.UnixNano()/int64(time.Millisecond))
+}
+
+func TestBuildTargetsAlertingQueries(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{}
+	req := &datasource.DatasourceRequest{}
+	req.TimeRange = &datasource.TimeRange{FromEpochMs: 1560761879121, ToEpochMs: 1560762879121}
+	req.Queries = []*datasource.Query{
+		{ModelJson: "{\"refId\": \"A\", \"program\": \"some program\"}"},
+		{ModelJson: "{\"refId\": \"B\", \"program\": \"some program\", \"datasourceId\": 1, \"alerting\": false}"},
+		{ModelJson: "{\"refId\": \"C\", \"program\": \"some program\", \"datasource\": {\"uid\": \"sfx\"}, \"alerting\": true}"},
+	}
+	// When
+	targets, err := ds.buildTargets(req, JobSettings{})
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(targets))
+	assert.False(t, targets[0].Alerting)
+	assert.False(t, targets[1].Alerting)
+	assert.True(t, targets[2].Alerting)
+}
+
 func TestCleanupInactiveJobHandlers(t *testing.T) {
 	// Given
 	ds := &SignalFxDatasource{
@@ -82,10 +157,12 @@ func TestCleanupInactiveJobHandlers(t *testing.T) {
 	job1 := new(signalflowJob)
 	job1.On("isActive").Return(true)
 	job1.On("Program").Return("program1")
+	job1.On("Datasource").Return("datasource1")
 	job1.On("stop")
 	job2 := new(signalflowJob)
 	job2.On("isActive").Return(false)
 	job2.On("Program").Return("program1")
+	job2.On("Datasource").Return("datasource1")
 	job2.On("stop")
 	ds.handlers = make([]SignalFxJob, 2)
 	ds.handlers[0] = job1
@@ -97,3 +174,109 @@ func TestCleanupInactiveJobHandlers(t *testing.T) {
 	job1.AssertNumberOfCalls(t, "stop", 0)
 	job2.AssertNumberOfCalls(t, "stop", 1)
 }
+
+func TestGetDsInfo(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{}
+	dsInfo := &datasource.DatasourceInfo{}
+	dsInfo.JsonData = "{\"inactiveJobTimeout\": 1200000, \"streamingThreshold\": 60000}"
+	dsInfo.DecryptedSecureJsonData = map[string]string{"accessToken": "secret"}
+	// When
+	info, err := ds.getDsInfo(dsInfo)
+	settings := info.jobSettings()
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", info.AccessToken)
+	assert.Equal(t, 20*time.Minute, settings.inactiveJobTimeout())
+	assert.Equal(t, time.Minute, settings.streamingThresholdTimeout())
+	assert.Equal(t, defaultCleanupInterval, settings.cleanupInterval())
+}
+
+func TestCleanupIntervalFollowsDatasources(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{}
+	ds.updateCleanupInterval("a", 10*time.Second)
+	ds.updateCleanupInterval("b", time.Minute)
+	// When
+	ds.updateCleanupInterval("a", 2*time.Minute)
+	// Then
+	assert.Equal(t, time.Minute, ds.getCleanupInterval())
+	assert.Equal(t, defaultCleanupInterval, (&SignalFxDatasource{}).getCleanupInterval())
+}
+
+func TestShutdown(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{
+		logger: datasourceHandlerTestLogger,
+		done:   make(chan struct{}),
+	}
+	job := new(signalflowJob)
+	job.On("Program").Return("program1")
+	job.On("Datasource").Return("datasource1")
+	job.On("stop")
+	ds.handlers = []SignalFxJob{job}
+	// When
+	ds.Shutdown(time.Second)
+	_, err := ds.Query(context.Background(), &datasource.DatasourceRequest{})
+	// Then
+	job.AssertNumberOfCalls(t, "stop", 1)
+	assert.Empty(t, ds.handlers)
+	assert.Equal(t, errShuttingDown, err)
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{
+		logger: datasourceHandlerTestLogger,
+		done:   make(chan struct{}),
+	}
+	stopped := new(signalflowJob)
+	stopped.On("Program").Return("program1")
+	stopped.On("Datasource").Return("datasource1")
+	stopped.On("stop")
+	blocked := new(signalflowJob)
+	blocked.On("Program").Return("program2")
+	blocked.On("Datasource").Return("datasource1")
+	blocked.On("stop").Run(func(mock.Arguments) { time.Sleep(time.Second) })
+	ds.handlers = []SignalFxJob{blocked, stopped}
+	client := new(signalflowClientMock)
+	client.On("Close")
+	ds.client = client
+	signals := make(chan os.Signal, 1)
+	exited := make(chan int, 1)
+	handleShutdownSignals(ds, signals, func(code int) { exited <- code })
+	defer signal.Stop(signals)
+	// When
+	start := time.Now()
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	// Then
+	select {
+	case code := <-exited:
+		assert.Equal(t, 0, code)
+		assert.True(t, time.Since(start) < 250*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("Plugin did not exit on SIGTERM")
+	}
+	stopped.AssertNumberOfCalls(t, "stop", 1)
+	client.AssertNumberOfCalls(t, "Close", 1)
+	assert.True(t, ds.isClosing())
+}
+
+func newAPITestServer(responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path+"?"+r.URL.RawQuery]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+}
+
+func newAPITestRequest(url string, modelJSON string) *datasource.DatasourceRequest {
+	return &datasource.DatasourceRequest{
+		Datasource: &datasource.DatasourceInfo{Name: "SignalFx", Url: url},
+		TimeRange:  &datasource.TimeRange{FromEpochMs: 1000, ToEpochMs: 10000},
+		Queries:    []*datasource.Query{{ModelJson: modelJSON}},
+	}
+}
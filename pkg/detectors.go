@@ -0,0 +1,129 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"golang.org/x/net/context"
+)
+
+const detectorsLimit = 1000
+
+type DetectorRule struct {
+	DetectLabel string `json:"detectLabel"`
+	Severity    string `json:"severity"`
+	Description string `json:"description"`
+	Disabled    bool   `json:"disabled"`
+}
+
+type DetectorResponseItem struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Tags        []string       `json:"tags"`
+	Rules       []DetectorRule `json:"rules"`
+	Created     int64          `json:"created"`
+	LastUpdated int64          `json:"lastUpdated"`
+}
+
+type DetectorResponse struct {
+	Count   int                    `json:"count"`
+	Results []DetectorResponseItem `json:"results"`
+}
+
+// getDetectors lists the detectors matching the name and tags parameters, one row per detector rule
+func (t *SignalFxDatasource) getDetectors(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
+	detectors, err := t.findDetectors(ctx, tsdbReq, apiCall)
+	if err != nil {
+		return nil, err
+	}
+	table := NewTableBuilder(
+		TableColumn{Name: "id", Type: stringColumn},
+		TableColumn{Name: "name", Type: stringColumn},
+		TableColumn{Name: "description", Type: stringColumn},
+		TableColumn{Name: "tags", Type: stringColumn},
+		TableColumn{Name: "rule", Type: stringColumn},
+		TableColumn{Name: "severity", Type: stringColumn},
+		TableColumn{Name: "ruleDisabled", Type: boolColumn},
+		TableColumn{Name: "lastUpdated", Type: timeColumn})
+	for _, d := range detectors {
+		rules := d.Rules
+		if len(rules) == 0 {
+			rules = []DetectorRule{{}}
+		}
+		for _, r := range rules {
+			table.AddRow(d.ID, d.Name, d.Description, strings.Join(d.Tags, ","), r.DetectLabel, r.Severity, r.Disabled, d.LastUpdated)
+		}
+	}
+	return table.Response(queryRefID(tsdbReq)), nil
+}
+
+func (t *SignalFxDatasource) findDetectors(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) ([]DetectorResponseItem, error) {
+	params, err := url.ParseQuery(apiCall.Query)
+	if err != nil {
+		return nil, err
+	}
+	if params.Get("limit") == "" {
+		params.Set("limit", fmt.Sprint(detectorsLimit))
+	}
+	call := &SignalFxApiCall{
+		Method: apiCall.Method,
+		Path:   "/v2/detector",
+		Query:  params.Encode(),
+	}
+	response := DetectorResponse{}
+	if err := t.makeAPICall(ctx, tsdbReq, call, &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+// getIncidents lists the active incidents. The incidents API can't filter by detector,
+// so name and tags parameters select the matching detectors first.
+func (t *SignalFxDatasource) getIncidents(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
+	params, err := url.ParseQuery(apiCall.Query)
+	if err != nil {
+		return nil, err
+	}
+	var detectorIDs map[string]bool
+	if params.Get("name") != "" || len(params["tags"]) > 0 {
+		filter := url.Values{"name": params["name"], "tags": params["tags"]}
+		detectors, err := t.findDetectors(ctx, tsdbReq, &SignalFxApiCall{Method: apiCall.Method, Query: filter.Encode()})
+		if err != nil {
+			return nil, err
+		}
+		detectorIDs = make(map[string]bool)
+		for _, d := range detectors {
+			detectorIDs[d.ID] = true
+		}
+	}
+	params.Del("name")
+	params.Del("tags")
+	if params.Get("limit") == "" {
+		params.Set("limit", fmt.Sprint(detectorsLimit))
+	}
+	apiCall.Query = params.Encode()
+	response := make([]IncidentResponseItem, 0)
+	if err := t.makeAPICall(ctx, tsdbReq, apiCall, &response); err != nil {
+		return nil, err
+	}
+	table := NewTableBuilder(
+		TableColumn{Name: "incidentId", Type: stringColumn},
+		TableColumn{Name: "detectorId", Type: stringColumn},
+		TableColumn{Name: "detectorName", Type: stringColumn},
+		TableColumn{Name: "severity", Type: stringColumn},
+		TableColumn{Name: "anomalyState", Type: stringColumn},
+		TableColumn{Name: "active", Type: boolColumn},
+		TableColumn{Name: "triggeredAt", Type: timeColumn})
+	for _, i := range response {
+		if detectorIDs != nil && !detectorIDs[i.DetectorID] {
+			continue
+		}
+		triggeredAt, _ := incidentAnnotation(i)
+		table.AddRow(i.IncidentID, i.DetectorID, i.DetectorName, i.Severity, i.AnomalyState, i.Active, triggeredAt.Time)
+	}
+	return table.Response(queryRefID(tsdbReq)), nil
+}
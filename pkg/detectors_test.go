@@ -0,0 +1,52 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestGetDetectors(t *testing.T) {
+	// Given
+	server := newAPITestServer(map[string]string{
+		"/v2/detector?limit=1000&name=CPU&tags=prod": `{"count": 1, "results": [{"id": "D1", "name": "CPU", "tags": ["prod", "infra"],
+			"rules": [{"detectLabel": "High", "severity": "Critical"}, {"detectLabel": "Low", "severity": "Info", "disabled": true}]}]}`,
+	})
+	defer server.Close()
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger, apiClient: NewSignalFxApiClient(datasourceHandlerTestLogger)}
+	req := newAPITestRequest(server.URL, `{"path": "/v2/detector", "query": "name=CPU&tags=prod"}`)
+	// When
+	response, err := ds.runQuery(context.Background(), req)
+	// Then
+	assert.Nil(t, err)
+	table := response.Results[0].Tables[0]
+	assert.Equal(t, 2, len(table.Rows))
+	assert.Equal(t, "prod,infra", table.Rows[0].Values[3].StringValue)
+	assert.Equal(t, "High", table.Rows[0].Values[4].StringValue)
+	assert.Equal(t, "Info", table.Rows[1].Values[5].StringValue)
+	assert.True(t, table.Rows[1].Values[6].BoolValue)
+}
+
+func TestGetIncidentsFilteredByDetectorTags(t *testing.T) {
+	// Given
+	server := newAPITestServer(map[string]string{
+		"/v2/detector?limit=1000&tags=prod": `{"count": 1, "results": [{"id": "D1", "name": "CPU"}]}`,
+		"/v2/incident?limit=1000": `[
+			{"incidentId": "I1", "detectorId": "D1", "detectorName": "CPU", "severity": "Critical", "anomalyState": "ANOMALOUS", "active": true,
+				"events": [{"timestamp": 2000, "anomalyState": "ANOMALOUS"}]},
+			{"incidentId": "I2", "detectorId": "D2", "detectorName": "Memory", "severity": "Minor", "anomalyState": "ANOMALOUS", "active": true}]`,
+	})
+	defer server.Close()
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger, apiClient: NewSignalFxApiClient(datasourceHandlerTestLogger)}
+	req := newAPITestRequest(server.URL, `{"path": "/v2/incident", "query": "tags=prod"}`)
+	// When
+	response, err := ds.runQuery(context.Background(), req)
+	// Then
+	assert.Nil(t, err)
+	table := response.Results[0].Tables[0]
+	assert.Equal(t, 1, len(table.Rows))
+	assert.Equal(t, "I1", table.Rows[0].Values[0].StringValue)
+	assert.Equal(t, int64(2000), table.Rows[0].Values[6].Int64Value)
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"golang.org/x/net/context"
+)
+
+const dimensionsPageSize = 1000
+
+// dimensionsMaxResults is the largest offset + limit accepted by the dimension search API
+const dimensionsMaxResults = 10000
+
+type DimensionResponseItem struct {
+	Key              string                 `json:"key"`
+	Value            string                 `json:"value"`
+	Description      string                 `json:"description"`
+	CustomProperties map[string]interface{} `json:"customProperties"`
+	Tags             []string               `json:"tags"`
+}
+
+type DimensionResponse struct {
+	Count   int                     `json:"count"`
+	Results []DimensionResponseItem `json:"results"`
+}
+
+// getDimensions runs a dimension search and returns one row per dimension,
+// with a column per custom property and per tag
+func (t *SignalFxDatasource) getDimensions(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall) (*datasource.DatasourceResponse, error) {
+	dimensions, err := t.searchDimensions(ctx, tsdbReq, apiCall, dimensionsPageSize)
+	if err != nil {
+		return nil, err
+	}
+	return dimensionTable(dimensions).Response(queryRefID(tsdbReq)), nil
+}
+
+// searchDimensions fetches the search results page by page, up to the limit parameter
+func (t *SignalFxDatasource) searchDimensions(ctx context.Context, tsdbReq *datasource.DatasourceRequest, apiCall *SignalFxApiCall, pageSize int) ([]DimensionResponseItem, error) {
+	params, err := url.ParseQuery(apiCall.Query)
+	if err != nil {
+		return nil, err
+	}
+	maxResults := dimensionsMaxResults
+	if limit, err := strconv.Atoi(params.Get("limit")); err == nil && limit > 0 && limit < maxResults {
+		maxResults = limit
+	}
+	offset := 0
+	if o, err := strconv.Atoi(params.Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+	dimensions := make([]DimensionResponseItem, 0)
+	for len(dimensions) < maxResults && offset < dimensionsMaxResults {
+		limit := int(min(int64(pageSize), int64(maxResults-len(dimensions))))
+		limit = int(min(int64(limit), int64(dimensionsMaxResults-offset)))
+		params.Set("limit", strconv.Itoa(limit))
+		params.Set("offset", strconv.Itoa(offset))
+		apiCall.Query = params.Encode()
+		response := DimensionResponse{}
+		if err := t.makeAPICall(ctx, tsdbReq, apiCall, &response); err != nil {
+			return nil, err
+		}
+		dimensions = append(dimensions, response.Results...)
+		offset += len(response.Results)
+		if len(response.Results) < limit || offset >= response.Count {
+			break
+		}
+	}
+	return dimensions, nil
+}
+
+// dimensionTable returns one row per dimension, with a string column per custom property and a bool column per tag
+func dimensionTable(dimensions []DimensionResponseItem) *TableBuilder {
+	propertySet := make(map[string]bool)
+	tagSet := make(map[string]bool)
+	for _, d := range dimensions {
+		for k := range d.CustomProperties {
+			propertySet[k] = true
+		}
+		for _, tag := range d.Tags {
+			tagSet[tag] = true
+		}
+	}
+	properties := sortedSet(propertySet)
+	tags := sortedSet(tagSet)
+
+	columns := []TableColumn{
+		{Name: "key", Type: stringColumn},
+		{Name: "value", Type: stringColumn},
+		{Name: "description", Type: stringColumn},
+	}
+	for _, p := range properties {
+		columns = append(columns, TableColumn{Name: p, Type: stringColumn})
+	}
+	for _, tag := range tags {
+		columns = append(columns, TableColumn{Name: "tag:" + tag, Type: boolColumn})
+	}
+	table := NewTableBuilder(columns...)
+	for _, d := range dimensions {
+		row := []interface{}{d.Key, d.Value, d.Description}
+		for _, p := range properties {
+			value := ""
+			if v, ok := d.CustomProperties[p]; ok && v != nil {
+				value = fmt.Sprint(v)
+			}
+			row = append(row, value)
+		}
+		dimensionTags := make(map[string]bool)
+		for _, tag := range d.Tags {
+			dimensionTags[tag] = true
+		}
+		for _, tag := range tags {
+			row = append(row, dimensionTags[tag])
+		}
+		table.AddRow(row...)
+	}
+	return table
+}
+
+func sortedSet(set map[string]bool) []string {
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
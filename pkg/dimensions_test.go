@@ -0,0 +1,50 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestDimensionTable(t *testing.T) {
+	// Given
+	dimensions := []DimensionResponseItem{
+		{Key: "host", Value: "a", CustomProperties: map[string]interface{}{"owner": "ops"}, Tags: []string{"prod"}},
+		{Key: "host", Value: "b", CustomProperties: map[string]interface{}{"team": "web"}},
+	}
+	// When
+	table := dimensionTable(dimensions).Table()
+	// Then
+	columns := make([]string, 0, len(table.Columns))
+	for _, c := range table.Columns {
+		columns = append(columns, c.Name)
+	}
+	assert.Equal(t, []string{"key", "value", "description", "owner", "team", "tag:prod"}, columns)
+	assert.Equal(t, "ops", table.Rows[0].Values[3].StringValue)
+	assert.Equal(t, datasource.RowValue_TYPE_BOOL, table.Rows[0].Values[5].Kind)
+	assert.True(t, table.Rows[0].Values[5].BoolValue)
+	assert.Equal(t, "web", table.Rows[1].Values[4].StringValue)
+	assert.Equal(t, datasource.RowValue_TYPE_BOOL, table.Rows[1].Values[5].Kind)
+	assert.False(t, table.Rows[1].Values[5].BoolValue)
+}
+
+func TestGetDimensionsPaging(t *testing.T) {
+	// Given
+	server := newAPITestServer(map[string]string{
+		"/v2/dimension?limit=2&offset=0&query=key%3Ahost": `{"count": 3, "results": [{"key": "host", "value": "a"}, {"key": "host", "value": "b"}]}`,
+		"/v2/dimension?limit=2&offset=2&query=key%3Ahost": `{"count": 3, "results": [{"key": "host", "value": "c"}]}`,
+	})
+	defer server.Close()
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger, apiClient: NewSignalFxApiClient(datasourceHandlerTestLogger)}
+	req := newAPITestRequest(server.URL, `{"path": "/v2/dimension", "query": "query=key:host"}`)
+	apiCall := &SignalFxApiCall{Path: "/v2/dimension", Query: "query=key:host"}
+	// When
+	dimensions, err := ds.searchDimensions(context.Background(), req, apiCall, 2)
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(dimensions))
+	assert.Equal(t, "c", dimensions[2].Value)
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"math"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+)
+
+// Downsampling methods applied when a time series has more points than the query's maxDataPoints
+const (
+	downsamplingLTTB    = "lttb"
+	downsamplingAverage = "average"
+)
+
+// downsample reduces the points to the threshold. The returned slice never shares the input's backing array.
+func downsample(points []*datasource.Point, threshold int, method string) []*datasource.Point {
+	if threshold <= 0 || len(points) <= threshold {
+		return append([]*datasource.Point(nil), points...)
+	}
+	if method == downsamplingAverage {
+		return downsampleAverage(points, threshold)
+	}
+	return downsampleLTTB(points, threshold)
+}
+
+// downsampleAverage averages consecutive points into threshold buckets, each reported at its first timestamp
+func downsampleAverage(points []*datasource.Point, threshold int) []*datasource.Point {
+	sampled := make([]*datasource.Point, 0, threshold)
+	bucketSize := float64(len(points)) / float64(threshold)
+	for i := 0; i < threshold; i++ {
+		start := int(float64(i) * bucketSize)
+		end := int(float64(i+1) * bucketSize)
+		if i == threshold-1 {
+			end = len(points)
+		}
+		if start >= end {
+			continue
+		}
+		sum := 0.0
+		for _, p := range points[start:end] {
+			sum += p.Value
+		}
+		sampled = append(sampled, &datasource.Point{Timestamp: points[start].Timestamp, Value: sum / float64(end-start)})
+	}
+	return sampled
+}
+
+// downsampleLTTB implements Largest-Triangle-Three-Buckets, which keeps the points shaping the graph
+func downsampleLTTB(points []*datasource.Point, threshold int) []*datasource.Point {
+	if threshold < 3 {
+		return downsampleAverage(points, threshold)
+	}
+	sampled := make([]*datasource.Point, 0, threshold)
+	sampled = append(sampled, points[0])
+	bucketSize := float64(len(points)-2) / float64(threshold-2)
+	a := 0
+	for i := 0; i < threshold-2; i++ {
+		// Average of the next bucket is the third vertex of the triangles
+		nextStart := int(float64(i+1)*bucketSize) + 1
+		nextEnd := int(float64(i+2)*bucketSize) + 1
+		if nextEnd > len(points) {
+			nextEnd = len(points)
+		}
+		avgX, avgY := 0.0, 0.0
+		for _, p := range points[nextStart:nextEnd] {
+			avgX += float64(p.Timestamp)
+			avgY += p.Value
+		}
+		count := float64(nextEnd - nextStart)
+		avgX /= count
+		avgY /= count
+
+		start := int(float64(i)*bucketSize) + 1
+		end := int(float64(i+1)*bucketSize) + 1
+		ax, ay := float64(points[a].Timestamp), points[a].Value
+		maxArea := -1.0
+		next := start
+		for j := start; j < end; j++ {
+			area := math.Abs((ax-avgX)*(points[j].Value-ay) - (ax-float64(points[j].Timestamp))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+		sampled = append(sampled, points[next])
+		a = next
+	}
+	return append(sampled, points[len(points)-1])
+}
@@ -0,0 +1,45 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownsampleLTTB(t *testing.T) {
+	// Given
+	points := makePoints(1000)
+	points[500].Value = 100
+	// When
+	sampled := downsample(points, 50, downsamplingLTTB)
+	// Then
+	assert.Equal(t, 50, len(sampled))
+	assert.Equal(t, points[0], sampled[0])
+	assert.Equal(t, points[999], sampled[49])
+	assert.Contains(t, sampled, points[500])
+}
+
+func TestDownsampleAverage(t *testing.T) {
+	// Given
+	points := makePoints(100)
+	// When
+	sampled := downsample(points, 10, downsamplingAverage)
+	// Then
+	assert.Equal(t, 10, len(sampled))
+	assert.Equal(t, int64(10000), sampled[1].Timestamp)
+	assert.Equal(t, 4.5, sampled[1].Value)
+}
+
+func TestConvertToTimeseriesDownsamples(t *testing.T) {
+	// Given
+	handler := &SignalFxJobHandler{
+		logger: datasourceHandlerTestLogger,
+		Points: map[int64][]*datasource.Point{1: makePoints(100)},
+	}
+	// When
+	series := handler.convertToTimeseries(seriesOptions{maxDataPoints: 20, downsampling: downsamplingAverage})
+	// Then
+	assert.Equal(t, 20, len(series[0].Points))
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+)
+
+// jobsPath is the query path listing the jobs of the datasource, as the legacy plugin protocol can only serve queries
+const jobsPath = "/_plugin/jobs"
+
+// bufferedPointSize estimates the memory of a buffered point and its pointer
+const bufferedPointSize = int64(unsafe.Sizeof(datasource.Point{}) + unsafe.Sizeof(uintptr(0)))
+
+var errJobAdminDisabled = errors.New("Job administration is not enabled for this datasource")
+
+// JobInfo describes a cached job for the job administration
+type JobInfo struct {
+	ID           string
+	Datasource   string
+	OrgID        int64
+	DatasourceID int64
+	Program      string
+	StartTime    time.Time
+	StopTime     time.Time
+	Resolution   time.Duration
+	Unbounded    bool
+	Pinned       bool
+	Running      bool
+	LastUsed     time.Time
+	TSIDs        int
+	Points       int
+}
+
+func (t *SignalFxJobHandler) info() JobInfo {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	info := JobInfo{
+		Datasource:   t.settings.Datasource,
+		OrgID:        t.settings.OrgID,
+		DatasourceID: t.settings.DatasourceID,
+		Program:      t.program,
+		StartTime:    t.startTime,
+		StopTime:     t.stopTime,
+		Unbounded:    t.unbounded,
+		Pinned:       t.pinned,
+		LastUsed:     t.lastUsed,
+		TSIDs:        len(t.Points),
+	}
+	if t.computation != nil {
+		info.ID = t.computation.Handle()
+		info.Resolution = t.computation.Resolution()
+		info.Running = !t.stopped && !t.computation.IsFinished()
+	}
+	for _, points := range t.Points {
+		info.Points += len(points)
+	}
+	return info
+}
+
+// getJobs lists the cached jobs of the datasource
+func (t *SignalFxDatasource) getJobs(tsdbReq *datasource.DatasourceRequest) (*datasource.DatasourceResponse, error) {
+	if err := t.checkJobAdmin(tsdbReq); err != nil {
+		return nil, err
+	}
+	t.handlerMutex.Lock()
+	handlers := make([]SignalFxJob, 0, len(t.handlers))
+	for _, h := range t.handlers {
+		if isDatasourceJob(h, tsdbReq.Datasource) {
+			handlers = append(handlers, h)
+		}
+	}
+	t.handlerMutex.Unlock()
+	table := NewTableBuilder(
+		TableColumn{Name: "jobId", Type: stringColumn},
+		TableColumn{Name: "datasource", Type: stringColumn},
+		TableColumn{Name: "program", Type: stringColumn},
+		TableColumn{Name: "from", Type: timeColumn},
+		TableColumn{Name: "to", Type: timeColumn},
+		TableColumn{Name: "resolution", Type: numberColumn},
+		TableColumn{Name: "unbounded", Type: boolColumn},
+		TableColumn{Name: "pinned", Type: boolColumn},
+		TableColumn{Name: "running", Type: boolColumn},
+		TableColumn{Name: "lastUsed", Type: timeColumn},
+		TableColumn{Name: "tsids", Type: numberColumn},
+		TableColumn{Name: "points", Type: numberColumn},
+		TableColumn{Name: "memoryBytes", Type: numberColumn})
+	for _, h := range handlers {
+		i := h.info()
+		table.AddRow(i.ID, i.Datasource, i.Program, i.StartTime, i.StopTime, int64(i.Resolution/time.Millisecond),
+			i.Unbounded, i.Pinned, i.Running, i.LastUsed, i.TSIDs, i.Points, int64(i.Points)*bufferedPointSize)
+	}
+	return table.Response(queryRefID(tsdbReq)), nil
+}
+
+// datasourceKey identifies the datasource by its ID within the organization
+func datasourceKey(info *datasource.DatasourceInfo) string {
+	return fmt.Sprintf("%d/%d", info.OrgId, info.Id)
+}
+
+// isDatasourceJob checks whether the job was started by the datasource, identified by its ID within the organization
+func isDatasourceJob(h SignalFxJob, info *datasource.DatasourceInfo) bool {
+	settings := h.Settings()
+	return settings.OrgID == info.OrgId && settings.DatasourceID == info.Id
+}
+
+// applyJobAdminSettings stops jobs when an admin requested it by saving the datasource, as only admins can change
+// the settings of a datasource: a new flushJobsAt stops all jobs of the datasource and clears its result cache,
+// stopJobId stops a single job. The settings are only checked when they changed since the previous query.
+func (t *SignalFxDatasource) applyJobAdminSettings(info *datasource.DatasourceInfo, dsInfo *DatasourceInfo) {
+	key := datasourceKey(info)
+	t.handlerMutex.Lock()
+	if t.settingsJSON[key] == info.JsonData {
+		t.handlerMutex.Unlock()
+		return
+	}
+	if t.settingsJSON == nil {
+		t.settingsJSON = make(map[string]string)
+		t.jobsFlushedAt = make(map[string]int64)
+	}
+	t.settingsJSON[key] = info.JsonData
+	flush := t.jobsFlushedAt[key] != dsInfo.FlushJobsAt
+	t.jobsFlushedAt[key] = dsInfo.FlushJobsAt
+	if flush {
+		delete(t.resultCaches, key)
+	}
+	t.handlerMutex.Unlock()
+	if flush {
+		stopped := t.removeJobHandlers(func(h SignalFxJob) bool {
+			return isDatasourceJob(h, info)
+		})
+		t.logger.Info("Flushed jobs", "datasource", info.Name, "jobs", stopped)
+	} else if dsInfo.StopJobID != "" {
+		stopped := t.removeJobHandlers(func(h SignalFxJob) bool {
+			return isDatasourceJob(h, info) && h.ID() == dsInfo.StopJobID
+		})
+		t.logger.Info("Stopped job", "datasource", info.Name, "jobId", dsInfo.StopJobID, "jobs", stopped)
+	}
+}
+
+// removeJobHandlers removes and stops the cached jobs matching the filter and returns their number
+func (t *SignalFxDatasource) removeJobHandlers(filter func(SignalFxJob) bool) int {
+	t.handlerMutex.Lock()
+	defer t.handlerMutex.Unlock()
+	kept := make([]SignalFxJob, 0, len(t.handlers))
+	stopped := 0
+	for _, h := range t.handlers {
+		if !filter(h) {
+			kept = append(kept, h)
+			continue
+		}
+		t.logger.Info("Stopping job on request", "program", h.Program(), "datasource", h.Datasource())
+		h.stop()
+		stopped++
+	}
+	t.handlers = kept
+	t.updateJobsMetric()
+	t.notifyJobSlotWaiters()
+	return stopped
+}
+
+// checkJobAdmin allows the job administration only on datasources where an admin enabled it
+func (t *SignalFxDatasource) checkJobAdmin(tsdbReq *datasource.DatasourceRequest) error {
+	dsInfo, err := t.getDsInfo(tsdbReq.Datasource)
+	if err != nil {
+		return err
+	}
+	if !dsInfo.JobAdmin {
+		return errJobAdminDisabled
+	}
+	return nil
+}
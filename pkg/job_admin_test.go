@@ -0,0 +1,169 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestJobAdminDisabled(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger}
+	tsdbReq := &datasource.DatasourceRequest{
+		Datasource: &datasource.DatasourceInfo{Id: 1, OrgId: 1, Name: "ds1", JsonData: "{}"},
+		Queries:    []*datasource.Query{{RefId: "A", ModelJson: "{\"path\": \"/_plugin/jobs\"}"}},
+	}
+	// When
+	_, err := ds.runQuery(context.Background(), tsdbReq)
+	// Then
+	assert.Equal(t, errJobAdminDisabled, err)
+}
+
+func TestGetJobs(t *testing.T) {
+	// Given
+	job := new(signalflowJob)
+	job.On("Settings").Return(JobSettings{Datasource: "ds1", OrgID: 1, DatasourceID: 1})
+	job.On("info").Return(JobInfo{ID: "job1", Datasource: "ds1", Program: "data('cpu').publish()", Running: true, TSIDs: 2, Points: 10})
+	otherDatasource := new(signalflowJob)
+	otherDatasource.On("Settings").Return(JobSettings{Datasource: "ds2", OrgID: 1, DatasourceID: 2})
+	otherOrg := new(signalflowJob)
+	otherOrg.On("Settings").Return(JobSettings{Datasource: "ds1", OrgID: 2, DatasourceID: 1})
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		handlers: []SignalFxJob{job, otherDatasource, otherOrg},
+	}
+	tsdbReq := &datasource.DatasourceRequest{
+		Datasource: &datasource.DatasourceInfo{Id: 1, OrgId: 1, Name: "ds1", JsonData: "{\"jobAdmin\": true}"},
+		Queries:    []*datasource.Query{{RefId: "A", ModelJson: "{\"path\": \"/_plugin/jobs\"}"}},
+	}
+	// When
+	response, err := ds.runQuery(context.Background(), tsdbReq)
+	// Then
+	assert.Nil(t, err)
+	table := response.Results[0].Tables[0]
+	assert.Equal(t, "A", response.Results[0].RefId)
+	assert.Equal(t, 13, len(table.Columns))
+	assert.Equal(t, 1, len(table.Rows))
+	values := table.Rows[0].Values
+	assert.Equal(t, "job1", values[0].StringValue)
+	assert.Equal(t, "ds1", values[1].StringValue)
+	assert.Equal(t, datasource.RowValue_TYPE_NULL, values[3].Kind)
+	assert.Equal(t, true, values[8].BoolValue)
+	assert.Equal(t, int64(2), values[10].Int64Value)
+	assert.Equal(t, int64(10), values[11].Int64Value)
+	assert.Equal(t, 10*bufferedPointSize, values[12].Int64Value)
+	otherDatasource.AssertNumberOfCalls(t, "info", 0)
+}
+
+func TestFlushJobs(t *testing.T) {
+	// Given
+	job1 := new(signalflowJob)
+	job1.On("Settings").Return(JobSettings{Datasource: "ds1", OrgID: 1, DatasourceID: 1})
+	job1.On("Program").Return("program1")
+	job1.On("Datasource").Return("ds1")
+	job1.On("stop")
+	job2 := new(signalflowJob)
+	job2.On("Settings").Return(JobSettings{Datasource: "ds2", OrgID: 1, DatasourceID: 2})
+	job2.On("Datasource").Return("ds2")
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		handlers: []SignalFxJob{job1, job2},
+	}
+	info := &datasource.DatasourceInfo{Id: 1, OrgId: 1, Name: "ds1", JsonData: "{\"flushJobsAt\": 1000}"}
+	// When
+	_, err := ds.getDsInfo(info)
+	// Then
+	assert.Nil(t, err)
+	job1.AssertNumberOfCalls(t, "stop", 1)
+	job2.AssertNumberOfCalls(t, "stop", 0)
+	assert.Equal(t, []SignalFxJob{job2}, ds.handlers)
+}
+
+func TestFlushJobsOnlyOnce(t *testing.T) {
+	// Given
+	job := new(signalflowJob)
+	job.On("Settings").Return(JobSettings{Datasource: "ds1", OrgID: 1, DatasourceID: 1})
+	job.On("stop")
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger}
+	ds.getDsInfo(&datasource.DatasourceInfo{Id: 1, OrgId: 1, Name: "ds1", JsonData: "{\"flushJobsAt\": 1000}"})
+	ds.handlers = []SignalFxJob{job}
+	// When
+	ds.getDsInfo(&datasource.DatasourceInfo{Id: 1, OrgId: 1, Name: "ds1", JsonData: "{\"flushJobsAt\": 1000}"})
+	ds.getDsInfo(&datasource.DatasourceInfo{Id: 1, OrgId: 1, Name: "ds1", JsonData: "{\"flushJobsAt\": 1000, \"maxJobs\": 10}"})
+	// Then
+	job.AssertNumberOfCalls(t, "stop", 0)
+	job.AssertNumberOfCalls(t, "Settings", 0)
+	assert.Equal(t, []SignalFxJob{job}, ds.handlers)
+}
+
+func TestStopJob(t *testing.T) {
+	// Given
+	job1 := new(signalflowJob)
+	job1.On("Settings").Return(JobSettings{Datasource: "ds1", OrgID: 1, DatasourceID: 1})
+	job1.On("ID").Return("job1")
+	job1.On("Program").Return("program1")
+	job1.On("Datasource").Return("ds1")
+	job1.On("stop")
+	job2 := new(signalflowJob)
+	job2.On("Settings").Return(JobSettings{Datasource: "ds1", OrgID: 1, DatasourceID: 1})
+	job2.On("ID").Return("job2")
+	job2.On("Datasource").Return("ds1")
+	otherDatasource := new(signalflowJob)
+	otherDatasource.On("Settings").Return(JobSettings{Datasource: "ds2", OrgID: 1, DatasourceID: 2})
+	otherDatasource.On("Datasource").Return("ds2")
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		handlers: []SignalFxJob{job1, job2, otherDatasource},
+	}
+	info := &datasource.DatasourceInfo{Id: 1, OrgId: 1, Name: "ds1", JsonData: "{\"stopJobId\": \"job1\"}"}
+	// When
+	_, err := ds.getDsInfo(info)
+	// Then
+	assert.Nil(t, err)
+	job1.AssertNumberOfCalls(t, "stop", 1)
+	otherDatasource.AssertNumberOfCalls(t, "ID", 0)
+	assert.Equal(t, []SignalFxJob{job2, otherDatasource}, ds.handlers)
+}
+
+func TestJobHandlerID(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Handle").Return("job1")
+	handler := &SignalFxJobHandler{computation: computation}
+	// Then
+	assert.Equal(t, "job1", handler.ID())
+	assert.Equal(t, "", (&SignalFxJobHandler{}).ID())
+}
+
+func TestJobHandlerInfo(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Handle").Return("job1")
+	computation.On("Resolution").Return(10 * time.Second)
+	computation.On("IsFinished").Return(false)
+	handler := &SignalFxJobHandler{
+		computation: computation,
+		program:     "some_program",
+		unbounded:   true,
+		settings:    JobSettings{Datasource: "ds1", OrgID: 1, DatasourceID: 2},
+		Points: map[int64]([]*datasource.Point){
+			1: {{Timestamp: 1000}, {Timestamp: 2000}},
+			2: {{Timestamp: 1000}},
+		},
+	}
+	// When
+	info := handler.info()
+	// Then
+	assert.Equal(t, "job1", info.ID)
+	assert.Equal(t, "ds1", info.Datasource)
+	assert.Equal(t, int64(2), info.DatasourceID)
+	assert.Equal(t, 10*time.Second, info.Resolution)
+	assert.True(t, info.Running)
+	assert.True(t, info.Unbounded)
+	assert.Equal(t, 2, info.TSIDs)
+	assert.Equal(t, 3, info.Points)
+}
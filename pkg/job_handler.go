@@ -3,6 +3,8 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana_plugin_model/go/datasource"
@@ -10,14 +12,39 @@ import (
 	"github.com/signalfx/signalfx-go/idtool"
 	"github.com/signalfx/signalfx-go/signalflow"
 	"github.com/signalfx/signalfx-go/signalflow/messages"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
 )
 
 type SignalflowClient interface {
-	Execute(req *signalflow.ExecuteRequest) (*signalflow.Computation, error)
+	Execute(req *signalflow.ExecuteRequest) (SignalflowComputation, error)
+}
+
+// SignalflowConnection is the SignalFlow client of the datasource, which is closed when its settings change
+type SignalflowConnection interface {
+	SignalflowClient
+	Close()
+}
+
+// signalflowConnection returns the computations of the SignalFlow client as SignalflowComputation
+type signalflowConnection struct {
+	*signalflow.Client
+}
+
+func (c signalflowConnection) Execute(req *signalflow.ExecuteRequest) (SignalflowComputation, error) {
+	comp, err := c.Client.Execute(req)
+	if err != nil {
+		return nil, err
+	}
+	return comp, nil
 }
 
 type SignalflowComputation interface {
+	Handle() string
 	Data() <-chan *messages.DataMessage
+	Info() <-chan *messages.InfoMessage
+	Expirations() <-chan *messages.ExpiredTSIDMessage
 	MaxDelay() time.Duration
 	Resolution() time.Duration
 	TSIDMetadata(tsid idtool.ID) *messages.MetadataProperties
@@ -30,63 +57,254 @@ type SignalflowComputation interface {
 type SignalFxJob interface {
 	stop()
 	Program() string
+	Datasource() string
+	Settings() JobSettings
+	ID() string
+	LastUsed() time.Time
 	isActive(time time.Time) bool
-	reuse(target *Target) <-chan []*datasource.TimeSeries
+	isRunning() bool
+	isIdle() bool
+	info() JobInfo
+	reuse(ctx context.Context, target *Target) <-chan *JobResult
 }
 
+// SignalFxJobHandler runs a single SignalFlow computation and buffers its datapoints.
+// The handler's mutable state is shared between the goroutine reading data messages,
+// the goroutine serving queries and the cleanup goroutine, so it is guarded by mutex.
 type SignalFxJobHandler struct {
-	logger      hclog.Logger
-	client      SignalflowClient
-	computation SignalflowComputation
-	batchOut    chan []*datasource.TimeSeries
-	program     string
-	interval    time.Duration
-	startTime   time.Time
-	stopTime    time.Time
-	cutoffTime  time.Time
-	maxDelay    int64
-	unbounded   bool
-	lastUsed    time.Time
-	Points      map[int64]([]*datasource.Point)
-	Meta        map[string]interface{}
-}
-
-const streamingThresholdTimeout = 2 * time.Minute
-const maxDatapointsToKeepBeforeTimerange = 10
-const inactiveJobTimeout = 6 * time.Minute
-
-func (t *SignalFxJobHandler) start(target *Target) (<-chan []*datasource.TimeSeries, error) {
-	t.batchOut = make(chan []*datasource.TimeSeries, 1)
+	mutex          sync.Mutex
+	logger         hclog.Logger
+	client         SignalflowClient
+	currentClient  func() SignalflowClient
+	computation    SignalflowComputation
+	batchOut       chan *JobResult
+	program        string
+	fingerprint    string
+	interval       time.Duration
+	startTime      time.Time
+	stopTime       time.Time
+	cutoffTime     time.Time
+	maxDelay       int64
+	unbounded      bool
+	lastUsed       time.Time
+	startedAt      time.Time
+	traceCtx       context.Context
+	dataSpan       trace.Span
+	cutoffSpan     trace.Span
+	settings       JobSettings
+	pinned         bool
+	filling        bool
+	stopped        bool
+	breaker        *circuitBreaker
+	resumeAttempts int
+	jobSlots       jobSlotFunc
+	jobCtx         context.Context
+	cancelJob      context.CancelFunc
+	waiters        []*jobWaiter
+	batchSeries    seriesOptions
+	trimmed        int
+	warnings       []string
+	Points         map[int64]([]*datasource.Point)
+	Meta           map[string]interface{}
+	gapMetadata    map[int64]*messages.MetadataProperties
+}
+
+// jobWaiter is a request subscribed to the initial data of an in-flight job
+type jobWaiter struct {
+	out        chan *JobResult
+	cutoffTime time.Time
+	series     seriesOptions
+}
+
+// seriesOptions control how the buffered data is returned to a request
+type seriesOptions struct {
+	labels        map[string]string
+	maxDataPoints int64
+	downsampling  string
+	startTime     time.Time
+	stopTime      time.Time
+	reused        bool
+	warnings      []string
+}
+
+// JobResult is the data returned to a request together with the execution metadata of the job
+type JobResult struct {
+	Series []*datasource.TimeSeries
+	Meta   ResultMeta
+}
+
+// ResultMeta describes how SignalFlow computed the data. Times and durations are in milliseconds.
+type ResultMeta struct {
+	JobID               string   `json:"jobId,omitempty"`
+	Resolution          int64    `json:"resolution"`
+	RequestedResolution int64    `json:"requestedResolution,omitempty"`
+	MaxDelay            int64    `json:"maxDelay"`
+	From                int64    `json:"from"`
+	To                  int64    `json:"to"`
+	Reused              bool     `json:"reused"`
+	Unbounded           bool     `json:"unbounded"`
+	TrimmedPoints       int      `json:"trimmedPoints"`
+	Warnings            []string `json:"warnings,omitempty"`
+	Cached              bool     `json:"cached,omitempty"`
+	// Complete is false when data may be missing, e.g. because a computation failed
+	Complete bool `json:"-"`
+}
+
+// timeRange is a half-open interval of time missing from a job's buffer
+type timeRange struct {
+	start time.Time
+	stop  time.Time
+}
+
+const defaultStreamingThresholdTimeout = 2 * time.Minute
+const defaultMaxDatapointsToKeepBeforeTimerange = 10
+const defaultInactiveJobTimeout = 6 * time.Minute
+const defaultPinnedJobTimeout = 24 * time.Hour
+const defaultCleanupInterval = 30 * time.Second
+const defaultAlertingQueryTimeout = 20 * time.Second
+const defaultJobQueueTimeout = 30 * time.Second
+const defaultQueryTimeout = 30 * time.Second
+
+// JobSettings controls the lifecycle of the jobs started for a datasource.
+// Zero values fall back to the defaults above.
+type JobSettings struct {
+	Datasource                         string
+	OrgID                              int64
+	DatasourceID                       int64
+	StreamingThresholdTimeout          time.Duration
+	InactiveJobTimeout                 time.Duration
+	PinnedJobTimeout                   time.Duration
+	MaxDatapointsToKeepBeforeTimerange int64
+	CleanupInterval                    time.Duration
+	AlertingQueryTimeout               time.Duration
+	MaxJobs                            int
+	GlobalMaxJobs                      int
+	JobQueueTimeout                    time.Duration
+	QueryTimeout                       time.Duration
+	ResultCacheMaxPoints               int64
+	ResultCacheTTL                     time.Duration
+}
+
+func (s JobSettings) streamingThresholdTimeout() time.Duration {
+	if s.StreamingThresholdTimeout > 0 {
+		return s.StreamingThresholdTimeout
+	}
+	return defaultStreamingThresholdTimeout
+}
+
+func (s JobSettings) inactiveJobTimeout() time.Duration {
+	if s.InactiveJobTimeout > 0 {
+		return s.InactiveJobTimeout
+	}
+	return defaultInactiveJobTimeout
+}
+
+func (s JobSettings) pinnedJobTimeout() time.Duration {
+	if s.PinnedJobTimeout > 0 {
+		return s.PinnedJobTimeout
+	}
+	return defaultPinnedJobTimeout
+}
+
+func (s JobSettings) maxDatapointsToKeepBeforeTimerange() int64 {
+	if s.MaxDatapointsToKeepBeforeTimerange > 0 {
+		return s.MaxDatapointsToKeepBeforeTimerange
+	}
+	return defaultMaxDatapointsToKeepBeforeTimerange
+}
+
+func (s JobSettings) cleanupInterval() time.Duration {
+	if s.CleanupInterval > 0 {
+		return s.CleanupInterval
+	}
+	return defaultCleanupInterval
+}
+
+func (s JobSettings) alertingQueryTimeout() time.Duration {
+	if s.AlertingQueryTimeout > 0 {
+		return s.AlertingQueryTimeout
+	}
+	return defaultAlertingQueryTimeout
+}
+
+func (s JobSettings) jobQueueTimeout() time.Duration {
+	if s.JobQueueTimeout > 0 {
+		return s.JobQueueTimeout
+	}
+	return defaultJobQueueTimeout
+}
+
+func (s JobSettings) queryTimeout() time.Duration {
+	if s.QueryTimeout > 0 {
+		return s.QueryTimeout
+	}
+	return defaultQueryTimeout
+}
+
+func (s JobSettings) resultCacheTTL() time.Duration {
+	if s.ResultCacheTTL > 0 {
+		return s.ResultCacheTTL
+	}
+	return defaultResultCacheTTL
+}
+
+func (t *SignalFxJobHandler) start(ctx context.Context, target *Target) (<-chan *JobResult, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	batchOut := make(chan *JobResult, 1)
+	t.batchOut = batchOut
 	t.initialize(target)
+	_, executeSpan := startSpan(ctx, "SignalFlow execute", trace.SpanKindClient)
+	executeSpan.SetAttributes(attribute.String("program", t.program), attribute.Bool("unbounded", t.unbounded))
 	comp, err := t.execute()
 	if err != nil {
 		t.logger.Error("Could not execute request", "error", err)
+		recordSpanError(executeSpan, err)
+		executeSpan.End()
 		return nil, err
 	}
+	executeSpan.End()
 	t.computation = comp
+	t.startedAt = time.Now()
+	t.traceCtx = ctx
+	_, t.dataSpan = startSpan(ctx, "SignalFlow first data message", trace.SpanKindInternal)
 
 	go t.readDataMessages()
 	t.updateLastUsed()
-	return t.batchOut, nil
+	return batchOut, nil
 }
 
 func (t *SignalFxJobHandler) initialize(target *Target) {
 	t.Points = make(map[int64]([]*datasource.Point))
 	t.program = target.Program
+	t.fingerprint = target.Fingerprint()
 	t.initializeTimeRange(target)
 	t.interval = target.Interval
 	t.maxDelay = target.MaxDelay
-	t.unbounded = target.StopTime.After(time.Now().Add(-streamingThresholdTimeout))
+	// Alerting queries always run as bounded computations returning their result at once
+	t.unbounded = !target.Alerting && target.StopTime.After(time.Now().Add(-t.settings.streamingThresholdTimeout()))
+	t.pinned = target.KeepStreaming && !target.Alerting
+	t.batchSeries = seriesOptions{
+		maxDataPoints: target.MaxDataPoints,
+		downsampling:  target.Downsampling,
+		startTime:     t.startTime,
+		stopTime:      t.cutoffTime,
+	}
 }
 
 func (t *SignalFxJobHandler) initializeTimeRange(target *Target) {
 	t.startTime = target.StartTime
 	t.stopTime = target.StopTime
-	t.cutoffTime = t.stopTime
+	t.cutoffTime = cutoffTime(t.stopTime)
+}
+
+// cutoffTime returns the time up to which data has to be collected before it can be returned
+func cutoffTime(stopTime time.Time) time.Time {
 	now := time.Now()
-	if t.stopTime.After(now) {
-		t.cutoffTime = now
+	if stopTime.After(now) {
+		return now
 	}
+	return stopTime
 }
 
 func min(x, y int64) int64 {
@@ -96,7 +314,7 @@ func min(x, y int64) int64 {
 	return x
 }
 
-func (t *SignalFxJobHandler) execute() (*signalflow.Computation, error) {
+func (t *SignalFxJobHandler) execute() (SignalflowComputation, error) {
 	request := &signalflow.ExecuteRequest{
 		Program: t.program,
 		Start:   t.startTime,
@@ -115,21 +333,56 @@ func (t *SignalFxJobHandler) execute() (*signalflow.Computation, error) {
 	return t.client.Execute(request)
 }
 
-func (t *SignalFxJobHandler) reuse(target *Target) <-chan []*datasource.TimeSeries {
+func (t *SignalFxJobHandler) reuse(ctx context.Context, target *Target) <-chan *JobResult {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// Requests for a job which is still loading its initial data wait for the data
+	// to reach their own cutoff time instead of starting an identical computation
+	if t.batchOut != nil {
+		if !t.isJobReusable(target) {
+			return nil
+		}
+		waiter := &jobWaiter{
+			out:        make(chan *JobResult, 1),
+			cutoffTime: cutoffTime(target.StopTime),
+			series:     t.seriesOptions(target),
+		}
+		t.waiters = append(t.waiters, waiter)
+		jobRequestsMetric.WithLabelValues(t.settings.Datasource, "coalesced").Inc()
+		t.pin(target)
+		t.updateLastUsed()
+		return waiter.out
+	}
 	// Re-use this handler only if it has already processed the initial request
 	// so that enough data is collected in the buffer and we can return it immediately
-	if t.isJobReusable(target) && t.batchOut == nil {
+	if t.filling {
+		return nil
+	}
+	if t.isJobReusable(target) {
 		t.initializeTimeRange(target)
-		out := make(chan []*datasource.TimeSeries, 1)
-		t.flushData(out)
+		out := make(chan *JobResult, 1)
+		t.flushData(out, t.seriesOptions(target))
+		jobRequestsMetric.WithLabelValues(t.settings.Datasource, "reused").Inc()
+		t.pin(target)
+		t.updateLastUsed()
+		return out
+	}
+	// Time ranges which only partially overlap are served by running bounded
+	// computations for the missing intervals and merging them into the buffer
+	if gaps := t.findGaps(target); len(gaps) > 0 {
+		out := make(chan *JobResult, 1)
+		t.filling = true
+		jobRequestsMetric.WithLabelValues(t.settings.Datasource, "gap_fill").Inc()
+		t.pin(target)
 		t.updateLastUsed()
+		go t.fillGaps(ctx, target, gaps, out)
 		return out
 	}
 	return nil
 }
 
 func (t *SignalFxJobHandler) isJobReusable(target *Target) bool {
-	return t.program == target.Program &&
+	return t.matchesProgram(target) &&
 		t.interval == target.Interval &&
 		t.maxDelay == target.MaxDelay &&
 		!t.startTime.After(target.StartTime) &&
@@ -137,53 +390,386 @@ func (t *SignalFxJobHandler) isJobReusable(target *Target) bool {
 			!t.stopTime.Before(target.StopTime))
 }
 
+// matchesProgram checks whether the target's program is equivalent to the program of this job
+func (t *SignalFxJobHandler) matchesProgram(target *Target) bool {
+	if t.program == target.Program {
+		return true
+	}
+	if t.fingerprint != target.Fingerprint() {
+		return false
+	}
+	_, ok := publishLabelMapping(t.program, target.Program)
+	return ok
+}
+
+// labelMapping returns the stream labels to use for an equivalent program with different publish() labels
+func (t *SignalFxJobHandler) labelMapping(target *Target) map[string]string {
+	if t.program == target.Program {
+		return nil
+	}
+	mapping, _ := publishLabelMapping(t.program, target.Program)
+	return mapping
+}
+
+func (t *SignalFxJobHandler) seriesOptions(target *Target) seriesOptions {
+	return seriesOptions{
+		labels:        t.labelMapping(target),
+		maxDataPoints: target.MaxDataPoints,
+		downsampling:  target.Downsampling,
+		startTime:     target.StartTime,
+		stopTime:      cutoffTime(target.StopTime),
+		reused:        true,
+	}
+}
+
+func (t *SignalFxJobHandler) findGaps(target *Target) []timeRange {
+	if !t.matchesProgram(target) ||
+		t.interval != target.Interval ||
+		t.maxDelay != target.MaxDelay ||
+		t.computation == nil ||
+		t.computation.Resolution() <= 0 {
+		return nil
+	}
+	running := !t.computation.IsFinished()
+	if t.unbounded && !running {
+		return nil
+	}
+	coveredStop := t.stopTime
+	if t.unbounded {
+		coveredStop = target.StopTime
+	}
+	if target.StopTime.Before(t.startTime) || target.StartTime.After(coveredStop) {
+		return nil
+	}
+	gaps := make([]timeRange, 0)
+	if target.StartTime.Before(t.startTime) {
+		gaps = append(gaps, timeRange{start: target.StartTime, stop: t.startTime})
+	}
+	if target.StopTime.After(coveredStop) {
+		// A missing interval which is still streaming can't be filled by a bounded computation
+		if target.StopTime.After(time.Now().Add(-t.settings.streamingThresholdTimeout())) {
+			return nil
+		}
+		gaps = append(gaps, timeRange{start: coveredStop, stop: target.StopTime})
+	}
+	return gaps
+}
+
+func (t *SignalFxJobHandler) fillGaps(ctx context.Context, target *Target, gaps []timeRange, out chan *JobResult) {
+	t.mutex.Lock()
+	resolution := t.computation.Resolution()
+	t.mutex.Unlock()
+	filled := true
+	for _, gap := range gaps {
+		release, err := t.acquireJobSlot(ctx)
+		if err != nil {
+			t.logger.Error("Could not start gap fill job", "error", err)
+			filled = false
+			continue
+		}
+		comp, err := t.executeGap(gap, resolution)
+		if err != nil {
+			release()
+			t.logger.Error("Could not execute gap fill request", "error", err)
+			filled = false
+			continue
+		}
+		points, metadata, err := t.readGapData(comp, t.settings.queryTimeout())
+		release()
+		if err != nil {
+			t.logger.Error("SignalFlow gap fill computation failed", "error", err)
+			filled = false
+			continue
+		}
+		t.mutex.Lock()
+		t.mergeGapData(points, metadata)
+		t.mutex.Unlock()
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.filling = false
+	if filled {
+		t.initializeTimeRange(target)
+	}
+	options := t.seriesOptions(target)
+	if !filled {
+		options.warnings = append(options.warnings, "Data for part of the time range could not be loaded")
+	}
+	t.flushData(out, options)
+	t.updateLastUsed()
+}
+
+// acquireJobSlot waits for a slot of an additional computation of the job within the job limits
+func (t *SignalFxJobHandler) acquireJobSlot(ctx context.Context) (func(), error) {
+	if t.jobSlots == nil {
+		return func() {}, nil
+	}
+	return t.jobSlots(ctx, t.settings)
+}
+
+// jobContext returns the context of the job's own requests, which is cancelled when the job stops.
+// It must be called with mutex held.
+func (t *SignalFxJobHandler) jobContext() context.Context {
+	if t.jobCtx == nil {
+		t.jobCtx, t.cancelJob = context.WithCancel(context.Background())
+	}
+	return t.jobCtx
+}
+
+func (t *SignalFxJobHandler) executeGap(gap timeRange, resolution time.Duration) (SignalflowComputation, error) {
+	request := &signalflow.ExecuteRequest{
+		Program:    t.program,
+		Start:      gap.start,
+		Stop:       gap.stop,
+		Resolution: resolution,
+		Immediate:  true,
+	}
+	if t.maxDelay > 0 {
+		request.MaxDelayMs = t.maxDelay
+	}
+	t.logger.Debug("Starting gap fill job", "program", t.program, "start", gap.start, "stop", gap.stop)
+	return t.client.Execute(request)
+}
+
+// readGapData collects the data of a gap fill computation until it finishes. The computation
+// is stopped when it doesn't finish within the timeout.
+func (t *SignalFxJobHandler) readGapData(comp SignalflowComputation, timeout time.Duration) (map[int64]([]*datasource.Point), map[int64]*messages.MetadataProperties, error) {
+	points := make(map[int64]([]*datasource.Point))
+	metadata := make(map[int64]*messages.MetadataProperties)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-comp.Done():
+			if err := comp.Err(); err != nil {
+				return nil, nil, err
+			}
+			return points, metadata, nil
+		case dm := <-comp.Data():
+			if dm == nil {
+				continue
+			}
+			appendDataMessage(points, dm)
+			for _, pl := range dm.Payloads {
+				tsid := int64(pl.TSID)
+				if _, ok := metadata[tsid]; !ok {
+					metadata[tsid] = comp.TSIDMetadata(pl.TSID)
+				}
+			}
+		case <-timer.C:
+			comp.Stop()
+			return nil, nil, fmt.Errorf("SignalFlow computation did not complete within %s", timeout)
+		}
+	}
+}
+
+func (t *SignalFxJobHandler) mergeGapData(points map[int64]([]*datasource.Point), metadata map[int64]*messages.MetadataProperties) {
+	for tsid, ps := range points {
+		t.Points[tsid] = mergePoints(t.Points[tsid], ps)
+	}
+	for tsid, meta := range metadata {
+		if meta == nil || t.computation.TSIDMetadata(idtool.ID(tsid)) != nil {
+			continue
+		}
+		if t.gapMetadata == nil {
+			t.gapMetadata = make(map[int64]*messages.MetadataProperties)
+		}
+		t.gapMetadata[tsid] = meta
+	}
+}
+
+// mergePoints merges two lists of points sorted by timestamp. Points from the first
+// list take precedence when both lists contain the same timestamp.
+func mergePoints(a, b []*datasource.Point) []*datasource.Point {
+	merged := make([]*datasource.Point, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].Timestamp < b[j].Timestamp:
+			merged = append(merged, a[i])
+			i++
+		case a[i].Timestamp > b[j].Timestamp:
+			merged = append(merged, b[j])
+			j++
+		default:
+			merged = append(merged, a[i])
+			i++
+			j++
+		}
+	}
+	merged = append(merged, a[i:]...)
+	return append(merged, b[j:]...)
+}
+
 func (t *SignalFxJobHandler) updateLastUsed() {
 	t.lastUsed = time.Now()
 }
 
+func (t *SignalFxJobHandler) pin(target *Target) {
+	if target.KeepStreaming {
+		t.pinned = true
+	}
+}
+
 func (t *SignalFxJobHandler) readDataMessages() {
 	for {
 		select {
 		// This channel receives when there is no more data
 		case <-t.computation.Done():
-			t.flushData(t.batchOut)
-			t.stop()
-			if err := t.computation.Err(); err != nil {
+			t.mutex.Lock()
+			err := t.computation.Err()
+			if t.resumeAttempts > 0 {
+				// The resumed computation ended before delivering any data
+				t.breaker.failure(time.Now())
+			}
+			if t.isResumable(err) {
+				t.logger.Warn("SignalFlow computation dropped, resuming", "program", t.program, "error", err)
+				t.mutex.Unlock()
+				if t.resume() {
+					continue
+				}
+				t.mutex.Lock()
+			}
+			if err != nil {
 				t.logger.Error("SignalFlow computation failed", "error", err)
+				computationErrorsMetric.WithLabelValues(t.settings.Datasource).Inc()
+				t.addWarning(fmt.Sprintf("SignalFlow computation failed: %s", err))
 			}
+			t.flushInitialData()
+			t.flushWaiters(nil)
+			t.mutex.Unlock()
+			t.stop()
 			return
 		case dm := <-t.computation.Data():
+			t.mutex.Lock()
+			if dm != nil && t.dataSpan != nil {
+				t.dataSpan.End()
+				t.dataSpan = nil
+				_, t.cutoffSpan = startSpan(t.traceCtx, "handleDataMessage cutoff", trace.SpanKindInternal)
+			}
+			if dm != nil {
+				t.resumedDataReceived()
+			}
 			if t.handleDataMessage(dm) {
-				t.flushData(t.batchOut)
+				t.flushInitialData()
+			}
+			if dm != nil {
+				t.flushWaiters(dm)
 			}
+			t.mutex.Unlock()
+		case im := <-t.computation.Info():
+			t.mutex.Lock()
+			stop := t.handleInfoMessage(im)
+			t.mutex.Unlock()
+			if stop {
+				t.stop()
+			}
+		case em := <-t.computation.Expirations():
+			t.mutex.Lock()
+			t.handleExpiredTSID(em)
+			t.mutex.Unlock()
+		}
+	}
+}
+
+// handleInfoMessage reports the SignalFlow info messages relevant to users and
+// returns true when the computation can be stopped
+func (t *SignalFxJobHandler) handleInfoMessage(m *messages.InfoMessage) bool {
+	if m == nil {
+		return false
+	}
+	block := m.MessageBlock
+	switch block.Code {
+	case messages.FindMatchedNoTimeseries:
+		// There is nothing to wait for, return the empty result right away
+		t.logger.Debug("Program matched no time series", "program", t.program)
+		t.addWarning("The program matched no time series")
+		t.flushInitialData()
+		t.flushWaiters(nil)
+		return true
+	case messages.FindLimitedResultSet:
+		warning := "Fetch limit exceeded, only some of the matching time series are returned"
+		if limit, ok := block.ContentsRaw["limitSize"]; ok {
+			warning = fmt.Sprintf("Fetch limit exceeded, only %v of %v matching time series are returned", limit, block.ContentsRaw["matchedSize"])
+		}
+		t.addWarning(warning)
+	case messages.GroupByMissingProperty:
+		t.addWarning("Some time series are missing the properties used to group by")
+	case messages.JobRunningResolution, messages.JobDetectedLag, messages.JobInitialMaxDelay:
+		t.logger.Debug("SignalFlow job info", "code", block.Code, "contents", block.ContentsRaw)
+	default:
+		if block.Level == "WARNING" || block.Level == "ERROR" {
+			t.addWarning(fmt.Sprintf("SignalFlow %s: %v", block.Code, block.ContentsRaw))
 		}
 	}
+	return false
+}
+
+// handleExpiredTSID drops the buffered data of a time series which stopped reporting
+func (t *SignalFxJobHandler) handleExpiredTSID(m *messages.ExpiredTSIDMessage) {
+	if m == nil {
+		return
+	}
+	tsid := int64(idtool.IDFromString(m.TSID))
+	if points, ok := t.Points[tsid]; ok {
+		droppedPointsMetric.WithLabelValues(t.settings.Datasource, "expired").Add(float64(len(points)))
+		delete(t.Points, tsid)
+	}
+	delete(t.gapMetadata, tsid)
 }
 
 func (t *SignalFxJobHandler) handleDataMessage(m *messages.DataMessage) bool {
 	if m != nil {
-		timestamp := time.Unix(0, int64(m.TimestampMillis)*int64(time.Millisecond))
-		for _, pl := range m.Payloads {
-			tsid := int64(pl.TSID)
-			value := pl.Value()
-			if (t.Points[tsid]) == nil {
-				t.Points[tsid] = make([]*datasource.Point, 0)
-			}
-			t.Points[tsid] = append(t.Points[tsid], &datasource.Point{
-				Timestamp: timestamp.UnixNano() / int64(time.Millisecond),
-				Value:     toFloat64(value),
-			})
+		timestamp := appendDataMessage(t.Points, m)
+		return t.isCutoffReached(timestamp, t.cutoffTime)
+	}
+	return false
+}
+
+func (t *SignalFxJobHandler) isCutoffReached(timestamp time.Time, cutoffTime time.Time) bool {
+	resolution := t.computation.Resolution()
+	if resolution > 0 {
+		maxDelay := t.computation.MaxDelay()
+		// Estimate the timestamp of the last datapoint already available in the system
+		nextEstimatedTimestamp := timestamp.Add(2*resolution - 1).Add(maxDelay).Truncate(resolution)
+		roundedCutoffTime := cutoffTime.Truncate(resolution)
+		return nextEstimatedTimestamp.After(roundedCutoffTime)
+	}
+	return false
+}
+
+// flushWaiters sends the buffered data to the waiting requests whose cutoff time has been
+// reached by the data message, or to all of them when there is no more data
+func (t *SignalFxJobHandler) flushWaiters(m *messages.DataMessage) {
+	pending := make([]*jobWaiter, 0)
+	for _, w := range t.waiters {
+		if m == nil || t.isCutoffReached(time.Unix(0, int64(m.TimestampMillis)*int64(time.Millisecond)), w.cutoffTime) {
+			t.sendData(w.out, w.series)
+		} else {
+			pending = append(pending, w)
 		}
-		resolution := t.computation.Resolution()
-		if resolution > 0 {
-			maxDelay := t.computation.MaxDelay()
-			// Estimate the timestamp of the last datapoint already available in the system
-			nextEstimatedTimestamp := timestamp.Add(2*resolution - 1).Add(maxDelay).Truncate(resolution)
-			roundedCutoffTime := t.cutoffTime.Truncate(resolution)
-			return nextEstimatedTimestamp.After(roundedCutoffTime)
+	}
+	t.waiters = pending
+}
+
+func appendDataMessage(points map[int64]([]*datasource.Point), m *messages.DataMessage) time.Time {
+	timestamp := time.Unix(0, int64(m.TimestampMillis)*int64(time.Millisecond))
+	for _, pl := range m.Payloads {
+		tsid := int64(pl.TSID)
+		value := pl.Value()
+		if (points[tsid]) == nil {
+			points[tsid] = make([]*datasource.Point, 0)
 		}
+		// A resumed computation repeats the last received timestamp
+		if n := len(points[tsid]); n > 0 && points[tsid][n-1].Timestamp >= timestamp.UnixNano()/int64(time.Millisecond) {
+			continue
+		}
+		points[tsid] = append(points[tsid], &datasource.Point{
+			Timestamp: timestamp.UnixNano() / int64(time.Millisecond),
+			Value:     toFloat64(value),
+		})
 	}
-	return false
+	return timestamp
 }
 
 func toFloat64(value interface{}) float64 {
@@ -200,81 +786,214 @@ func toFloat64(value interface{}) float64 {
 }
 
 func (t *SignalFxJobHandler) stop() {
+	t.mutex.Lock()
+	t.stopped = true
+	computation := t.computation
+	if t.cancelJob != nil {
+		t.cancelJob()
+	}
+	t.mutex.Unlock()
 	t.logger.Debug("Stopping job", "program", t.program)
-	t.computation.Stop()
+	computation.Stop()
+}
+
+// flushInitialData returns the data to the request which started the job
+func (t *SignalFxJobHandler) flushInitialData() {
+	if t.batchOut != nil {
+		timeToFirstFlushMetric.WithLabelValues(t.settings.Datasource).Observe(time.Since(t.startedAt).Seconds())
+	}
+	for _, s := range []trace.Span{t.dataSpan, t.cutoffSpan} {
+		if s != nil {
+			s.SetAttributes(
+				attribute.Stringer("resolution", t.computation.Resolution()),
+				attribute.Stringer("maxDelay", t.computation.MaxDelay()),
+				attribute.String("cutoffTime", t.cutoffTime.Format(time.RFC3339)))
+			s.End()
+		}
+	}
+	t.dataSpan = nil
+	t.cutoffSpan = nil
+	t.flushData(t.batchOut, t.batchSeries)
 }
 
-func (t *SignalFxJobHandler) flushData(out chan []*datasource.TimeSeries) {
+func (t *SignalFxJobHandler) flushData(out chan *JobResult, options seriesOptions) {
 	t.batchOut = nil
+	t.sendData(out, options)
+}
+
+func (t *SignalFxJobHandler) sendData(out chan *JobResult, options seriesOptions) {
 	if out != nil {
 		t.trimDatapoints()
-		series := t.convertToTimeseries()
-		out <- series
+		series := t.convertToTimeseries(options)
+		out <- &JobResult{Series: series, Meta: t.resultMeta(options)}
 	}
 }
 
-func (t *SignalFxJobHandler) convertToTimeseries() []*datasource.TimeSeries {
+func (t *SignalFxJobHandler) resultMeta(options seriesOptions) ResultMeta {
+	meta := ResultMeta{
+		RequestedResolution: int64(t.interval / time.Millisecond),
+		From:                options.startTime.UnixNano() / int64(time.Millisecond),
+		To:                  options.stopTime.UnixNano() / int64(time.Millisecond),
+		Reused:              options.reused,
+		Unbounded:           t.unbounded,
+		TrimmedPoints:       t.trimmed,
+		Warnings:            append(append([]string(nil), t.warnings...), options.warnings...),
+		Complete:            len(t.warnings) == 0 && len(options.warnings) == 0,
+	}
+	if t.computation != nil {
+		meta.JobID = t.computation.Handle()
+		meta.Resolution = int64(t.computation.Resolution() / time.Millisecond)
+		meta.MaxDelay = int64(t.computation.MaxDelay() / time.Millisecond)
+	}
+	if options.maxDataPoints > 0 {
+		for _, points := range t.Points {
+			if int64(len(points)) > options.maxDataPoints {
+				meta.Warnings = append(meta.Warnings, fmt.Sprintf("Data was downsampled to %d points per time series", options.maxDataPoints))
+				break
+			}
+		}
+	}
+	return meta
+}
+
+// addWarning records a warning which is reported with all further results of the job
+func (t *SignalFxJobHandler) addWarning(warning string) {
+	for _, w := range t.warnings {
+		if w == warning {
+			return
+		}
+	}
+	t.warnings = append(t.warnings, warning)
+}
+
+func (t *SignalFxJobHandler) convertToTimeseries(options seriesOptions) []*datasource.TimeSeries {
 	series := make([]*datasource.TimeSeries, 0)
 	for id, points := range t.Points {
-		s := &datasource.TimeSeries{Name: t.getTimeSeriesName(idtool.ID(id)), Points: points, Tags: t.getTags(idtool.ID(id))}
+		if options.maxDataPoints > 0 && int64(len(points)) > options.maxDataPoints {
+			// SignalFx returned more points than requested, e.g. because of the min resolution
+			points = downsample(points, int(options.maxDataPoints), options.downsampling)
+		} else {
+			// Copy the points so that the reader goroutine can keep appending to the buffer
+			points = append([]*datasource.Point(nil), points...)
+		}
+		s := &datasource.TimeSeries{Name: t.getTimeSeriesName(idtool.ID(id)), Points: points, Tags: t.getTags(idtool.ID(id), options.labels)}
 		series = append(series, s)
 	}
 	return series
 }
 
-func (t *SignalFxJobHandler) getTimeSeriesName(tsid idtool.ID) string {
+func (t *SignalFxJobHandler) tsidMetadata(tsid idtool.ID) *messages.MetadataProperties {
 	if t.computation != nil {
-		meta := t.computation.TSIDMetadata(tsid)
-		if meta != nil {
-			if meta.OriginatingMetric != "" {
-				return meta.OriginatingMetric
-			}
-			return meta.Metric
+		if meta := t.computation.TSIDMetadata(tsid); meta != nil {
+			return meta
 		}
 	}
+	// Time series which only appeared in gap fill computations
+	return t.gapMetadata[int64(tsid)]
+}
+
+func (t *SignalFxJobHandler) getTimeSeriesName(tsid idtool.ID) string {
+	meta := t.tsidMetadata(tsid)
+	if meta != nil {
+		if meta.OriginatingMetric != "" {
+			return meta.OriginatingMetric
+		}
+		return meta.Metric
+	}
 	return "series_name"
 }
 
-func (t *SignalFxJobHandler) getTags(tsid idtool.ID) map[string]string {
+func (t *SignalFxJobHandler) getTags(tsid idtool.ID, labels map[string]string) map[string]string {
 	tags := make(map[string]string)
-	if t.computation != nil {
-		meta := t.computation.TSIDMetadata(tsid)
-		if meta != nil {
-			for tagName, tagValue := range meta.CustomProperties {
-				jsonValue, err := json.Marshal(tagValue)
-				if err != nil {
-					t.logger.Error("Could not marshal tag value", "value", tagValue, "error", err)
-					continue
-				}
-				tags[tagName] = string(jsonValue)
+	meta := t.tsidMetadata(tsid)
+	if meta != nil {
+		for tagName, tagValue := range meta.CustomProperties {
+			jsonValue, err := json.Marshal(tagValue)
+			if err != nil {
+				t.logger.Error("Could not marshal tag value", "value", tagValue, "error", err)
+				continue
 			}
-			for tagName, tagValue := range meta.InternalProperties {
-				jsonValue, err := json.Marshal(tagValue)
-				if err != nil {
-					t.logger.Error("Could not marshal tag value", "value", tagValue, "error", err)
-					continue
-				}
-				tags[tagName] = string(jsonValue)
+			tags[tagName] = string(jsonValue)
+		}
+		for tagName, tagValue := range meta.InternalProperties {
+			if label, ok := tagValue.(string); ok && tagName == "sf_streamLabel" && labels[label] != "" {
+				tagValue = labels[label]
 			}
+			jsonValue, err := json.Marshal(tagValue)
+			if err != nil {
+				t.logger.Error("Could not marshal tag value", "value", tagValue, "error", err)
+				continue
+			}
+			tags[tagName] = string(jsonValue)
 		}
 	}
 	return tags
 }
 
 func (t *SignalFxJobHandler) trimDatapoints() {
-	trimTimestamp := t.startTime.Add(-time.Duration(maxDatapointsToKeepBeforeTimerange * int64(t.computation.Resolution())))
+	trimTimestamp := t.startTime.Add(-time.Duration(t.settings.maxDatapointsToKeepBeforeTimerange() * int64(t.computation.Resolution())))
+	dropped := 0
 	for tsid, ss := range t.Points {
 		for len(ss) > 0 && trimTimestamp.After(time.Unix(0, ss[0].Timestamp*int64(time.Millisecond))) {
 			ss = ss[1:]
+			dropped++
 		}
 		t.Points[tsid] = ss
 	}
+	if dropped > 0 {
+		t.trimmed += dropped
+		droppedPointsMetric.WithLabelValues(t.settings.Datasource, "trimmed").Add(float64(dropped))
+	}
 }
 
 func (t *SignalFxJobHandler) isActive(now time.Time) bool {
-	return now.Before(t.lastUsed.Add(inactiveJobTimeout))
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	// Pinned jobs keep streaming for wallboards which refresh less often than the inactivity timeout
+	if t.pinned {
+		return now.Before(t.lastUsed.Add(t.settings.pinnedJobTimeout()))
+	}
+	return now.Before(t.lastUsed.Add(t.settings.inactiveJobTimeout()))
+}
+
+// isRunning checks whether the computation counts against the SignalFlow job limits
+func (t *SignalFxJobHandler) isRunning() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.computation != nil && !t.stopped && !t.computation.IsFinished()
+}
+
+// isIdle checks whether the job can be stopped without failing pending requests
+func (t *SignalFxJobHandler) isIdle() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.batchOut == nil && len(t.waiters) == 0 && !t.filling && !t.pinned
+}
+
+func (t *SignalFxJobHandler) LastUsed() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.lastUsed
 }
 
 func (t *SignalFxJobHandler) Program() string {
 	return t.program
 }
+
+func (t *SignalFxJobHandler) Datasource() string {
+	return t.settings.Datasource
+}
+
+func (t *SignalFxJobHandler) Settings() JobSettings {
+	return t.settings
+}
+
+// ID returns the SignalFlow job ID of the current computation
+func (t *SignalFxJobHandler) ID() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.computation == nil {
+		return ""
+	}
+	return t.computation.Handle()
+}
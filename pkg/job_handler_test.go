@@ -2,6 +2,9 @@
 package main
 
 import (
+	"encoding/binary"
+	"math"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/signalfx/signalfx-go/signalflow/messages"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"golang.org/x/net/context"
 )
 
 var jobHandlerTestLogger = hclog.New(&hclog.LoggerOptions{
@@ -27,9 +31,18 @@ type signalflowComputationMock struct {
 	mock.Mock
 }
 
-func (m *signalflowClientMock) Execute(req *signalflow.ExecuteRequest) (*signalflow.Computation, error) {
+func (m *signalflowClientMock) Execute(req *signalflow.ExecuteRequest) (SignalflowComputation, error) {
 	args := m.Called(req)
-	return args.Get(0).(*signalflow.Computation), args.Error(1)
+	return args.Get(0).(SignalflowComputation), args.Error(1)
+}
+
+func (m *signalflowClientMock) Close() {
+	m.Called()
+}
+
+func (m *signalflowComputationMock) Handle() string {
+	args := m.Called()
+	return args.String(0)
 }
 
 func (m *signalflowComputationMock) IsFinished() bool {
@@ -42,6 +55,21 @@ func (m *signalflowComputationMock) Data() <-chan *messages.DataMessage {
 	return args.Get(0).(<-chan *messages.DataMessage)
 }
 
+func (m *signalflowComputationMock) Info() <-chan *messages.InfoMessage {
+	args := m.Called()
+	return args.Get(0).(<-chan *messages.InfoMessage)
+}
+
+func (m *signalflowComputationMock) Expirations() <-chan *messages.ExpiredTSIDMessage {
+	args := m.Called()
+	return args.Get(0).(<-chan *messages.ExpiredTSIDMessage)
+}
+
+func (m *signalflowComputationMock) Events() <-chan *messages.EventMessage {
+	args := m.Called()
+	return args.Get(0).(<-chan *messages.EventMessage)
+}
+
 func (m *signalflowComputationMock) Done() <-chan struct{} {
 	args := m.Called()
 	return args.Get(0).(<-chan struct{})
@@ -68,7 +96,7 @@ func (m *signalflowComputationMock) Stop() error {
 }
 
 func (m *signalflowComputationMock) TSIDMetadata(tsid idtool.ID) *messages.MetadataProperties {
-	args := m.Called()
+	args := m.Called(tsid)
 	return args.Get(0).(*messages.MetadataProperties)
 }
 
@@ -76,7 +104,7 @@ func TestInitialize(t *testing.T) {
 	// Given
 	client := signalflow.Client{}
 	handler := &SignalFxJobHandler{
-		client: &client,
+		client: signalflowConnection{&client},
 	}
 	target := &Target{
 		StartTime: time.Now().Add(-time.Duration(time.Minute * 15)),
@@ -92,6 +120,23 @@ func TestInitialize(t *testing.T) {
 	assert.Equal(t, true, handler.unbounded)
 }
 
+func TestInitializeForAlertingQuery(t *testing.T) {
+	// Given
+	handler := &SignalFxJobHandler{}
+	target := &Target{
+		StartTime:     time.Now().Add(-time.Duration(time.Minute * 15)),
+		StopTime:      time.Now(),
+		Program:       "some_program",
+		KeepStreaming: true,
+		Alerting:      true,
+	}
+	// When
+	handler.initialize(target)
+	// Then
+	assert.Equal(t, false, handler.unbounded)
+	assert.Equal(t, false, handler.pinned)
+}
+
 func TestInitializeTimeRange(t *testing.T) {
 	// Given
 	handler := &SignalFxJobHandler{}
@@ -123,11 +168,28 @@ func TestIsActive(t *testing.T) {
 	assert.False(t, inactive)
 }
 
+func TestIsActiveForPinnedJob(t *testing.T) {
+	// Given
+	handler := &SignalFxJobHandler{
+		lastUsed: time.Now().Add(-time.Duration(time.Minute * 10)),
+		settings: JobSettings{PinnedJobTimeout: time.Hour},
+	}
+	// When
+	handler.pin(&Target{KeepStreaming: true})
+	active := handler.isActive(time.Now())
+	inactive := handler.isActive(time.Now().Add(time.Hour))
+	// Then
+	assert.True(t, active)
+	assert.False(t, inactive)
+}
+
 func TestReusePrefetchedData(t *testing.T) {
 	// Given
 	computation := new(signalflowComputationMock)
 	computation.On("IsFinished").Return(false)
 	computation.On("Resolution").Return(time.Second)
+	computation.On("MaxDelay").Return(time.Duration(0))
+	computation.On("Handle").Return("")
 	client := new(signalflowClientMock)
 	target := &Target{
 		StartTime: time.Now().Add(-time.Duration(time.Minute * 10)),
@@ -147,7 +209,7 @@ func TestReusePrefetchedData(t *testing.T) {
 		Points:      make(map[int64]([]*datasource.Point)),
 	}
 	// When
-	reused := handler.reuse(target)
+	reused := handler.reuse(context.Background(), target)
 	// Then
 	assert.NotNil(t, reused)
 }
@@ -258,7 +320,7 @@ func TestReadDataMessage(t *testing.T) {
 		Interval:  time.Duration(time.Second),
 	}
 	computation := new(signalflowComputationMock)
-	batchOut := make(chan []*datasource.TimeSeries)
+	batchOut := make(chan *JobResult)
 	handler := &SignalFxJobHandler{
 		logger:      jobHandlerTestLogger,
 		startTime:   time.Now().Add(-time.Duration(time.Minute * 20)),
@@ -274,7 +336,10 @@ func TestReadDataMessage(t *testing.T) {
 	done := make(chan struct{})
 	computation.On("Done").Return(modifyDone(done))
 	computation.On("Data").Return(modifyData(data))
+	computation.On("Info").Return((<-chan *messages.InfoMessage)(nil))
+	computation.On("Expirations").Return((<-chan *messages.ExpiredTSIDMessage)(nil))
 	computation.On("MaxDelay").Return(time.Second)
+	computation.On("Handle").Return("")
 	computation.On("Resolution").Return(time.Second)
 	customProperties := make(map[string]string)
 	customProperties["metric_source"] = "kubernetes"
@@ -298,7 +363,7 @@ func TestReadDataMessage(t *testing.T) {
 	data <- message
 	// When
 	go handler.readDataMessages()
-	c := <-handler.batchOut
+	c := (<-batchOut).Series
 	// Then
 	assert.Equal(t, 1, len(c))
 	assert.Equal(t, metadata.Metric, c[0].Name)
@@ -310,6 +375,82 @@ func TestReadDataMessage(t *testing.T) {
 	assert.Equal(t, expectedTags, c[0].Tags)
 }
 
+func TestConcurrentReuseAndStreaming(t *testing.T) {
+	// Given
+	target := &Target{
+		StartTime: time.Now().Add(-time.Duration(time.Minute * 15)),
+		StopTime:  time.Now(),
+		Program:   "some_program",
+		Interval:  time.Duration(time.Second),
+	}
+	computation := new(signalflowComputationMock)
+	batchOut := make(chan *JobResult, 1)
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		startTime:   target.StartTime,
+		stopTime:    target.StopTime,
+		cutoffTime:  target.StopTime,
+		interval:    target.Interval,
+		computation: computation,
+		batchOut:    batchOut,
+		unbounded:   true,
+		program:     "some_program",
+		Points:      make(map[int64]([]*datasource.Point)),
+	}
+	data := make(chan *messages.DataMessage)
+	done := make(chan struct{})
+	computation.On("Done").Return(modifyDone(done))
+	computation.On("Data").Return(modifyData(data))
+	computation.On("Info").Return((<-chan *messages.InfoMessage)(nil))
+	computation.On("Expirations").Return((<-chan *messages.ExpiredTSIDMessage)(nil))
+	computation.On("MaxDelay").Return(time.Second)
+	computation.On("Handle").Return("")
+	computation.On("Resolution").Return(time.Second)
+	computation.On("IsFinished").Return(false)
+	computation.On("Err").Return(nil)
+	computation.On("Stop").Return(nil)
+	computation.On("TSIDMetadata", mock.Anything).Return((*messages.MetadataProperties)(nil))
+	go handler.readDataMessages()
+	// When
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timestamp := target.StopTime.Add(-time.Minute)
+		for i := 0; i < 100; i++ {
+			message := &messages.DataMessage{}
+			message.TimestampMillis = uint64(timestamp.Add(time.Duration(i)*time.Second).UnixNano() / int64(time.Millisecond))
+			message.Payloads = []messages.DataPayload{{Type: 1, TSID: idtool.ID(i % 3)}}
+			data <- message
+		}
+	}()
+	first := <-batchOut
+	reused := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hits := 0
+			for j := 0; j < 20; j++ {
+				if ch := handler.reuse(context.Background(), target); ch != nil {
+					<-ch
+					hits++
+				}
+				handler.isActive(time.Now())
+			}
+			reused <- hits
+		}()
+	}
+	wg.Wait()
+	close(done)
+	close(reused)
+	// Then
+	assert.NotEmpty(t, first)
+	for hits := range reused {
+		assert.Equal(t, 20, hits)
+	}
+}
+
 func modifyDone(ch chan struct{}) <-chan struct{} {
 	return ch
 }
@@ -317,3 +458,412 @@ func modifyDone(ch chan struct{}) <-chan struct{} {
 func modifyData(ch chan *messages.DataMessage) <-chan *messages.DataMessage {
 	return ch
 }
+
+// doublePayload returns a data payload with a double value for the time series
+func doublePayload(tsid idtool.ID, value float64) messages.DataPayload {
+	payload := messages.DataPayload{Type: messages.ValTypeDouble, TSID: tsid}
+	binary.BigEndian.PutUint64(payload.Val[:], math.Float64bits(value))
+	return payload
+}
+
+// makePoints returns n points one second apart
+func makePoints(n int) []*datasource.Point {
+	points := make([]*datasource.Point, 0, n)
+	for i := 0; i < n; i++ {
+		points = append(points, &datasource.Point{Timestamp: int64(i * 1000), Value: float64(i % 10)})
+	}
+	return points
+}
+
+func TestFindGapsForZoomedOutRange(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("IsFinished").Return(false)
+	computation.On("Resolution").Return(time.Second)
+	now := time.Now()
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		startTime:   now.Add(-time.Duration(time.Minute * 15)),
+		stopTime:    now,
+		interval:    time.Duration(time.Second),
+		computation: computation,
+		unbounded:   true,
+		program:     "some_program",
+	}
+	target := &Target{
+		StartTime: now.Add(-time.Duration(time.Minute * 30)),
+		StopTime:  now,
+		Program:   "some_program",
+		Interval:  time.Duration(time.Second),
+	}
+	// When
+	gaps := handler.findGaps(target)
+	// Then
+	assert.Equal(t, []timeRange{{start: target.StartTime, stop: handler.startTime}}, gaps)
+}
+
+func TestFindGapsForShiftedFixedPeriod(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("IsFinished").Return(true)
+	computation.On("Resolution").Return(time.Second)
+	stopTime := time.Now().Add(-time.Duration(time.Hour * 24))
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		startTime:   stopTime.Add(-time.Duration(time.Hour)),
+		stopTime:    stopTime,
+		interval:    time.Duration(time.Second),
+		computation: computation,
+		unbounded:   false,
+		program:     "some_program",
+	}
+	target := &Target{
+		StartTime: handler.startTime.Add(time.Duration(time.Minute * 30)),
+		StopTime:  stopTime.Add(time.Duration(time.Minute * 30)),
+		Program:   "some_program",
+		Interval:  time.Duration(time.Second),
+	}
+	// When
+	gaps := handler.findGaps(target)
+	// Then
+	assert.Equal(t, []timeRange{{start: stopTime, stop: target.StopTime}}, gaps)
+}
+
+func TestFindGapsForDisjointRange(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("IsFinished").Return(true)
+	computation.On("Resolution").Return(time.Second)
+	stopTime := time.Now().Add(-time.Duration(time.Hour * 24))
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		startTime:   stopTime.Add(-time.Duration(time.Hour)),
+		stopTime:    stopTime,
+		interval:    time.Duration(time.Second),
+		computation: computation,
+		unbounded:   false,
+		program:     "some_program",
+	}
+	target := &Target{
+		StartTime: stopTime.Add(time.Duration(time.Hour)),
+		StopTime:  stopTime.Add(time.Duration(time.Hour * 2)),
+		Program:   "some_program",
+		Interval:  time.Duration(time.Second),
+	}
+	// When
+	gaps := handler.findGaps(target)
+	// Then
+	assert.Nil(t, gaps)
+}
+
+func TestMergePoints(t *testing.T) {
+	// Given
+	cached := []*datasource.Point{{Timestamp: 3000, Value: 3}, {Timestamp: 4000, Value: 4}}
+	filled := []*datasource.Point{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}, {Timestamp: 3000, Value: 30}}
+	// When
+	merged := mergePoints(cached, filled)
+	// Then
+	assert.Equal(t, []*datasource.Point{
+		{Timestamp: 1000, Value: 1},
+		{Timestamp: 2000, Value: 2},
+		{Timestamp: 3000, Value: 3},
+		{Timestamp: 4000, Value: 4},
+	}, merged)
+}
+
+func TestReadGapData(t *testing.T) {
+	// Given
+	handler := &SignalFxJobHandler{
+		logger: jobHandlerTestLogger,
+	}
+	computation := new(signalflowComputationMock)
+	data := make(chan *messages.DataMessage, 1)
+	done := make(chan struct{})
+	metadata := &messages.MetadataProperties{Metric: "metric_name"}
+	computation.On("Done").Return(modifyDone(done))
+	computation.On("Data").Return(modifyData(data))
+	computation.On("Info").Return((<-chan *messages.InfoMessage)(nil))
+	computation.On("Expirations").Return((<-chan *messages.ExpiredTSIDMessage)(nil))
+	computation.On("Err").Return(nil)
+	computation.On("TSIDMetadata", mock.Anything).Return(metadata)
+	message := &messages.DataMessage{TimestampMillis: 1000}
+	message.Payloads = []messages.DataPayload{{Type: 1, TSID: idtool.ID(123)}}
+	data <- message
+	// When
+	go func() {
+		for len(data) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		close(done)
+	}()
+	points, meta, err := handler.readGapData(computation, time.Minute)
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(points[123]))
+	assert.Equal(t, int64(1000), points[123][0].Timestamp)
+	assert.Equal(t, metadata, meta[123])
+}
+
+func TestReadGapDataTimesOut(t *testing.T) {
+	// Given
+	handler := &SignalFxJobHandler{
+		logger: jobHandlerTestLogger,
+	}
+	computation := new(signalflowComputationMock)
+	computation.On("Done").Return(modifyDone(make(chan struct{})))
+	computation.On("Data").Return(modifyData(make(chan *messages.DataMessage)))
+	computation.On("Stop").Return(nil)
+	// When
+	_, _, err := handler.readGapData(computation, 10*time.Millisecond)
+	// Then
+	assert.NotNil(t, err)
+	computation.AssertNumberOfCalls(t, "Stop", 1)
+}
+
+func TestFillGapsWaitsForJobSlot(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Resolution").Return(time.Second)
+	computation.On("MaxDelay").Return(time.Duration(0))
+	computation.On("Handle").Return("")
+	client := new(signalflowClientMock)
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		client:      client,
+		computation: computation,
+		filling:     true,
+		Points:      make(map[int64]([]*datasource.Point)),
+		jobSlots: func(ctx context.Context, settings JobSettings) (func(), error) {
+			return nil, errTooManyJobs
+		},
+	}
+	now := time.Now()
+	target := &Target{StartTime: now.Add(-time.Hour), StopTime: now.Add(-time.Minute * 30)}
+	out := make(chan *JobResult, 1)
+	// When
+	handler.fillGaps(context.Background(), target, []timeRange{{start: target.StartTime, stop: target.StopTime}}, out)
+	// Then
+	client.AssertNumberOfCalls(t, "Execute", 0)
+	assert.False(t, handler.filling)
+	assert.Contains(t, (<-out).Meta.Warnings, "Data for part of the time range could not be loaded")
+}
+
+func TestFillGapsHonoursQueryContext(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Resolution").Return(time.Second)
+	computation.On("MaxDelay").Return(time.Duration(0))
+	computation.On("Handle").Return("")
+	client := new(signalflowClientMock)
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		client:      client,
+		computation: computation,
+		filling:     true,
+		Points:      make(map[int64]([]*datasource.Point)),
+		jobSlots: func(ctx context.Context, settings JobSettings) (func(), error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	now := time.Now()
+	target := &Target{StartTime: now.Add(-time.Hour), StopTime: now.Add(-time.Minute * 30)}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	out := make(chan *JobResult, 1)
+	// When
+	handler.fillGaps(ctx, target, []timeRange{{start: target.StartTime, stop: target.StopTime}}, out)
+	// Then
+	client.AssertNumberOfCalls(t, "Execute", 0)
+	assert.Contains(t, (<-out).Meta.Warnings, "Data for part of the time range could not be loaded")
+}
+
+func TestReuseEquivalentProgram(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("IsFinished").Return(false)
+	computation.On("Resolution").Return(time.Second)
+	computation.On("MaxDelay").Return(time.Duration(0))
+	computation.On("Handle").Return("")
+	internalProperties := make(map[string]interface{})
+	internalProperties["sf_streamLabel"] = "A"
+	computation.On("TSIDMetadata", mock.Anything).Return(&messages.MetadataProperties{
+		Metric:             "cpu",
+		InternalProperties: internalProperties,
+	})
+	target := &Target{
+		StartTime: time.Now().Add(-time.Duration(time.Minute * 10)),
+		StopTime:  time.Now(),
+		Program:   "data( 'cpu' ).publish(label='B')",
+		Interval:  time.Duration(time.Second),
+	}
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		startTime:   time.Now().Add(-time.Duration(time.Minute * 15)),
+		stopTime:    time.Now(),
+		unbounded:   true,
+		program:     "data('cpu').publish(label='A')",
+		fingerprint: programFingerprint("data('cpu').publish(label='A')"),
+		computation: computation,
+		interval:    time.Duration(time.Second),
+		Points:      map[int64]([]*datasource.Point){123: {{Timestamp: time.Now().UnixNano() / int64(time.Millisecond)}}},
+	}
+	// When
+	reused := handler.reuse(context.Background(), target)
+	// Then
+	assert.NotNil(t, reused)
+	series := (<-reused).Series
+	assert.Equal(t, 1, len(series))
+	assert.Equal(t, "\"B\"", series[0].Tags["sf_streamLabel"])
+}
+
+func TestReuseInFlightJob(t *testing.T) {
+	// Given
+	now := time.Now()
+	target := &Target{
+		StartTime: now.Add(-time.Duration(time.Minute * 15)),
+		StopTime:  now.Add(-time.Duration(time.Second * 10)),
+		Program:   "some_program",
+		Interval:  time.Duration(time.Second),
+	}
+	computation := new(signalflowComputationMock)
+	batchOut := make(chan *JobResult, 1)
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		startTime:   target.StartTime,
+		stopTime:    target.StopTime,
+		cutoffTime:  target.StopTime,
+		interval:    target.Interval,
+		computation: computation,
+		batchOut:    batchOut,
+		unbounded:   true,
+		program:     "some_program",
+		Points:      make(map[int64]([]*datasource.Point)),
+	}
+	data := make(chan *messages.DataMessage, 1)
+	done := make(chan struct{})
+	computation.On("Done").Return(modifyDone(done))
+	computation.On("Data").Return(modifyData(data))
+	computation.On("Info").Return((<-chan *messages.InfoMessage)(nil))
+	computation.On("Expirations").Return((<-chan *messages.ExpiredTSIDMessage)(nil))
+	computation.On("MaxDelay").Return(time.Duration(0))
+	computation.On("Handle").Return("")
+	computation.On("Resolution").Return(time.Second)
+	computation.On("IsFinished").Return(false)
+	computation.On("TSIDMetadata", mock.Anything).Return((*messages.MetadataProperties)(nil))
+	// When
+	waiter := handler.reuse(context.Background(), target)
+	later := handler.reuse(context.Background(), &Target{
+		StartTime: target.StartTime,
+		StopTime:  now,
+		Program:   "some_program",
+		Interval:  time.Duration(time.Second),
+	})
+	message := &messages.DataMessage{}
+	message.TimestampMillis = uint64(target.StopTime.UnixNano() / int64(time.Millisecond))
+	message.Payloads = []messages.DataPayload{{Type: 1, TSID: idtool.ID(123)}}
+	handler.mutex.Lock()
+	if handler.handleDataMessage(message) {
+		handler.flushData(handler.batchOut, seriesOptions{})
+	}
+	handler.flushWaiters(message)
+	handler.mutex.Unlock()
+	// Then
+	assert.NotNil(t, waiter)
+	assert.NotNil(t, later)
+	assert.Equal(t, 1, len((<-batchOut).Series))
+	assert.Equal(t, 1, len((<-waiter).Series))
+	assert.Equal(t, 0, len(later))
+	assert.Equal(t, 1, len(handler.waiters))
+}
+
+func TestResultMeta(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Resolution").Return(10 * time.Second)
+	computation.On("MaxDelay").Return(2 * time.Second)
+	computation.On("Handle").Return("DxYz")
+	handler := &SignalFxJobHandler{
+		computation: computation,
+		interval:    time.Second,
+		unbounded:   true,
+		trimmed:     5,
+		Points:      map[int64][]*datasource.Point{1: makePoints(20)},
+	}
+	options := seriesOptions{
+		startTime:     time.Unix(1000, 0),
+		stopTime:      time.Unix(2000, 0),
+		reused:        true,
+		maxDataPoints: 10,
+	}
+	// When
+	meta := handler.resultMeta(options)
+	// Then
+	assert.Equal(t, ResultMeta{
+		JobID:               "DxYz",
+		Resolution:          10000,
+		RequestedResolution: 1000,
+		MaxDelay:            2000,
+		From:                1000000,
+		To:                  2000000,
+		Reused:              true,
+		Unbounded:           true,
+		TrimmedPoints:       5,
+		Warnings:            []string{"Data was downsampled to 10 points per time series"},
+		Complete:            true,
+	}, meta)
+}
+
+func TestHandleInfoMessageNoTimeseries(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Resolution").Return(time.Second)
+	computation.On("MaxDelay").Return(time.Duration(0))
+	computation.On("Handle").Return("")
+	batchOut := make(chan *JobResult, 1)
+	handler := &SignalFxJobHandler{
+		logger:      datasourceHandlerTestLogger,
+		computation: computation,
+		batchOut:    batchOut,
+		Points:      make(map[int64]([]*datasource.Point)),
+	}
+	message := &messages.InfoMessage{MessageBlock: messages.MessageBlock{Code: messages.FindMatchedNoTimeseries}}
+	// When
+	stop := handler.handleInfoMessage(message)
+	// Then
+	assert.True(t, stop)
+	result := <-batchOut
+	assert.Equal(t, 0, len(result.Series))
+	assert.Equal(t, []string{"The program matched no time series"}, result.Meta.Warnings)
+}
+
+func TestHandleInfoMessageLimitedResultSet(t *testing.T) {
+	// Given
+	handler := &SignalFxJobHandler{logger: datasourceHandlerTestLogger}
+	message := &messages.InfoMessage{MessageBlock: messages.MessageBlock{
+		Code:        messages.FindLimitedResultSet,
+		ContentsRaw: map[string]interface{}{"limitSize": 10000, "matchedSize": 12000},
+	}}
+	// When
+	stop := handler.handleInfoMessage(message)
+	handler.handleInfoMessage(message)
+	// Then
+	assert.False(t, stop)
+	assert.Equal(t, []string{"Fetch limit exceeded, only 10000 of 12000 matching time series are returned"}, handler.warnings)
+}
+
+func TestHandleExpiredTSID(t *testing.T) {
+	// Given
+	handler := &SignalFxJobHandler{
+		logger: datasourceHandlerTestLogger,
+		Points: map[int64]([]*datasource.Point){
+			123: {{Timestamp: 1000, Value: 1}},
+			456: {{Timestamp: 1000, Value: 2}},
+		},
+	}
+	// When
+	handler.handleExpiredTSID(&messages.ExpiredTSIDMessage{TSID: idtool.ID(123).String()})
+	// Then
+	assert.Equal(t, 1, len(handler.Points))
+	assert.NotNil(t, handler.Points[456])
+}
@@ -0,0 +1,231 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxJobsEnv limits the running SignalFlow jobs of all datasources, e.g. SIGNALFX_PLUGIN_MAX_JOBS=200
+const maxJobsEnv = "SIGNALFX_PLUGIN_MAX_JOBS"
+
+// jobSlotFunc waits for a slot of a computation which is not cached and returns the function releasing it
+type jobSlotFunc func(ctx context.Context, settings JobSettings) (func(), error)
+
+// jobSlotPollInterval is how often queued requests check for jobs which finished on their own
+const jobSlotPollInterval = time.Second
+
+var errTooManyJobs = errors.New("Too many SignalFlow jobs are running, try again later")
+
+// jobSlotWaiter is a request queued until it may start a job
+type jobSlotWaiter struct {
+	datasource string
+	maxJobs    int
+	wake       chan struct{}
+}
+
+func maxJobsFromEnv() int {
+	maxJobs, err := strconv.Atoi(os.Getenv(maxJobsEnv))
+	if err != nil || maxJobs < 0 {
+		return 0
+	}
+	return maxJobs
+}
+
+// acquireJobSlot waits until a job of the datasource can start within the global and per-datasource limits.
+// Requests are served in order: a queued request which fits takes precedence over later ones.
+// When reuse returns data from a job started in the meantime, that data is returned instead.
+// It must be called with handlerMutex held; the mutex is released while waiting.
+func (t *SignalFxDatasource) acquireJobSlot(ctx context.Context, settings JobSettings, reuse func() <-chan *JobResult) (<-chan *JobResult, error) {
+	var waiter *jobSlotWaiter
+	defer func() {
+		if waiter != nil {
+			t.dequeueJobSlotWaiter(waiter)
+		}
+	}()
+	deadline := time.Now().Add(settings.jobQueueTimeout())
+	for {
+		if reuse != nil {
+			if ch := reuse(); ch != nil {
+				return ch, nil
+			}
+		}
+		if t.hasJobSlot(settings.Datasource, settings.MaxJobs, waiter) {
+			return nil, nil
+		}
+		if t.evictIdleJob(settings.Datasource, settings.MaxJobs) {
+			continue
+		}
+		if waiter == nil {
+			waiter = &jobSlotWaiter{datasource: settings.Datasource, maxJobs: settings.MaxJobs, wake: make(chan struct{}, 1)}
+			t.slotWaiters = append(t.slotWaiters, waiter)
+			jobRequestsMetric.WithLabelValues(settings.Datasource, "queued").Inc()
+		}
+		if err := t.waitForJobSlot(ctx, waiter, deadline); err != nil {
+			jobRequestsMetric.WithLabelValues(settings.Datasource, "rejected").Inc()
+			return nil, err
+		}
+	}
+}
+
+func (t *SignalFxDatasource) waitForJobSlot(ctx context.Context, waiter *jobSlotWaiter, deadline time.Time) error {
+	t.handlerMutex.Unlock()
+	defer t.handlerMutex.Lock()
+	wait := time.Until(deadline)
+	if wait <= 0 {
+		return errTooManyJobs
+	}
+	if wait > jobSlotPollInterval {
+		wait = jobSlotPollInterval
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-waiter.wake:
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (t *SignalFxDatasource) dequeueJobSlotWaiter(waiter *jobSlotWaiter) {
+	for i, w := range t.slotWaiters {
+		if w == waiter {
+			t.slotWaiters = append(t.slotWaiters[:i], t.slotWaiters[i+1:]...)
+			break
+		}
+	}
+	t.notifyJobSlotWaiters()
+}
+
+// notifyJobSlotWaiters wakes the queued requests to check the limits again. It must be called with handlerMutex held.
+func (t *SignalFxDatasource) notifyJobSlotWaiters() {
+	for _, w := range t.slotWaiters {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// hasJobSlot checks the limits after reserving slots for the requests queued before the waiter
+func (t *SignalFxDatasource) hasJobSlot(datasource string, maxJobs int, waiter *jobSlotWaiter) bool {
+	counts, total := t.runningJobs()
+	for _, w := range t.slotWaiters {
+		if w == waiter {
+			break
+		}
+		if t.fitsJobLimits(counts, total, w.datasource, w.maxJobs) {
+			counts[w.datasource]++
+			total++
+		}
+	}
+	return t.fitsJobLimits(counts, total, datasource, maxJobs)
+}
+
+func (t *SignalFxDatasource) fitsJobLimits(counts map[string]int, total int, datasource string, maxJobs int) bool {
+	globalMaxJobs := t.globalMaxJobs()
+	return (globalMaxJobs <= 0 || total < globalMaxJobs) && (maxJobs <= 0 || counts[datasource] < maxJobs)
+}
+
+// globalMaxJobs returns the lowest limit of all datasources set by the environment or the datasource settings
+func (t *SignalFxDatasource) globalMaxJobs() int {
+	maxJobs := t.maxJobs
+	for _, m := range t.configuredMaxJobs {
+		if m > 0 && (maxJobs <= 0 || m < maxJobs) {
+			maxJobs = m
+		}
+	}
+	return maxJobs
+}
+
+// updateGlobalMaxJobs records the limit of all datasources configured on the datasource
+func (t *SignalFxDatasource) updateGlobalMaxJobs(datasource string, maxJobs int) {
+	t.handlerMutex.Lock()
+	defer t.handlerMutex.Unlock()
+	if t.configuredMaxJobs == nil {
+		t.configuredMaxJobs = make(map[string]int)
+	}
+	t.configuredMaxJobs[datasource] = maxJobs
+	t.notifyJobSlotWaiters()
+}
+
+// runningJobs counts the running computations per datasource and in total
+func (t *SignalFxDatasource) runningJobs() (map[string]int, int) {
+	counts := make(map[string]int)
+	total := 0
+	for _, h := range t.handlers {
+		if h.isRunning() {
+			counts[h.Datasource()]++
+			total++
+		}
+	}
+	for datasource, count := range t.transientJobs {
+		counts[datasource] += count
+		total += count
+	}
+	return counts, total
+}
+
+// evictIdleJob stops the least recently used running job without pending requests, from any datasource
+// when the global limit is reached or from the given datasource otherwise. Pinned jobs are kept.
+func (t *SignalFxDatasource) evictIdleJob(datasource string, maxJobs int) bool {
+	counts, total := t.runningJobs()
+	globalMaxJobs := t.globalMaxJobs()
+	anyDatasource := globalMaxJobs > 0 && total >= globalMaxJobs
+	if !anyDatasource && (maxJobs <= 0 || counts[datasource] < maxJobs) {
+		return false
+	}
+	evict := -1
+	for i, h := range t.handlers {
+		if !h.isRunning() || !h.isIdle() || (!anyDatasource && h.Datasource() != datasource) {
+			continue
+		}
+		if evict < 0 || h.LastUsed().Before(t.handlers[evict].LastUsed()) {
+			evict = i
+		}
+	}
+	if evict < 0 {
+		return false
+	}
+	h := t.handlers[evict]
+	t.logger.Debug("Evicting idle job", "program", h.Program(), "datasource", h.Datasource())
+	t.handlers = append(t.handlers[:evict], t.handlers[evict+1:]...)
+	h.stop()
+	jobRequestsMetric.WithLabelValues(h.Datasource(), "evicted").Inc()
+	t.updateJobsMetric()
+	return true
+}
+
+// acquireTransientJob waits for a slot of a computation which is not cached, e.g. an alerting query,
+// a gap fill or an annotation query, and returns the function releasing the slot
+func (t *SignalFxDatasource) acquireTransientJob(ctx context.Context, settings JobSettings) (func(), error) {
+	t.handlerMutex.Lock()
+	defer t.handlerMutex.Unlock()
+	if _, err := t.acquireJobSlot(ctx, settings, nil); err != nil {
+		return nil, err
+	}
+	if t.transientJobs == nil {
+		t.transientJobs = make(map[string]int)
+	}
+	t.transientJobs[settings.Datasource]++
+	return func() {
+		t.releaseTransientJob(settings.Datasource)
+	}, nil
+}
+
+// releaseTransientJob frees the slot of a job which is not cached
+func (t *SignalFxDatasource) releaseTransientJob(datasource string) {
+	t.handlerMutex.Lock()
+	defer t.handlerMutex.Unlock()
+	t.transientJobs[datasource]--
+	if t.transientJobs[datasource] <= 0 {
+		delete(t.transientJobs, datasource)
+	}
+	t.notifyJobSlotWaiters()
+}
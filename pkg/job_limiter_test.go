@@ -0,0 +1,216 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestHasJobSlotRespectsLimits(t *testing.T) {
+	// Given
+	job := new(signalflowJob)
+	job.On("isRunning").Return(true)
+	job.On("Datasource").Return("ds1")
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		maxJobs:  3,
+		handlers: []SignalFxJob{job, job},
+	}
+	// Then
+	assert.True(t, ds.hasJobSlot("ds1", 0, nil))
+	assert.False(t, ds.hasJobSlot("ds1", 2, nil))
+	assert.True(t, ds.hasJobSlot("ds2", 2, nil))
+	ds.transientJobs = map[string]int{"ds2": 1}
+	assert.False(t, ds.hasJobSlot("ds2", 2, nil))
+}
+
+func TestHasJobSlotServesQueueInOrder(t *testing.T) {
+	// Given
+	job := new(signalflowJob)
+	job.On("isRunning").Return(true)
+	job.On("Datasource").Return("ds1")
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		maxJobs:  2,
+		handlers: []SignalFxJob{job},
+	}
+	blocked := &jobSlotWaiter{datasource: "ds1", maxJobs: 1}
+	first := &jobSlotWaiter{datasource: "ds2"}
+	second := &jobSlotWaiter{datasource: "ds3"}
+	ds.slotWaiters = []*jobSlotWaiter{blocked, first, second}
+	// Then
+	assert.True(t, ds.hasJobSlot("ds2", 0, first))
+	assert.False(t, ds.hasJobSlot("ds3", 0, second))
+}
+
+func TestEvictIdleJobStopsLeastRecentlyUsed(t *testing.T) {
+	// Given
+	now := time.Now()
+	busy := new(signalflowJob)
+	busy.On("isRunning").Return(true)
+	busy.On("isIdle").Return(false)
+	busy.On("Datasource").Return("ds1")
+	busy.On("LastUsed").Return(now.Add(-time.Hour))
+	older := new(signalflowJob)
+	older.On("isRunning").Return(true)
+	older.On("isIdle").Return(true)
+	older.On("Datasource").Return("ds1")
+	older.On("LastUsed").Return(now.Add(-time.Minute))
+	older.On("Program").Return("program")
+	older.On("stop")
+	newer := new(signalflowJob)
+	newer.On("isRunning").Return(true)
+	newer.On("isIdle").Return(true)
+	newer.On("Datasource").Return("ds1")
+	newer.On("LastUsed").Return(now)
+	other := new(signalflowJob)
+	other.On("isRunning").Return(true)
+	other.On("isIdle").Return(true)
+	other.On("Datasource").Return("ds2")
+	other.On("LastUsed").Return(now.Add(-time.Hour))
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		handlers: []SignalFxJob{busy, older, newer, other},
+	}
+	// When
+	evicted := ds.evictIdleJob("ds1", 3)
+	// Then
+	assert.True(t, evicted)
+	older.AssertNumberOfCalls(t, "stop", 1)
+	busy.AssertNumberOfCalls(t, "stop", 0)
+	other.AssertNumberOfCalls(t, "stop", 0)
+	assert.Equal(t, []SignalFxJob{busy, newer, other}, ds.handlers)
+}
+
+func TestEvictIdleJobBelowLimit(t *testing.T) {
+	// Given
+	idle := new(signalflowJob)
+	idle.On("isRunning").Return(true)
+	idle.On("isIdle").Return(true)
+	idle.On("Datasource").Return("ds1")
+	idle.On("LastUsed").Return(time.Now())
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		handlers: []SignalFxJob{idle},
+	}
+	// Then
+	assert.False(t, ds.evictIdleJob("ds1", 2))
+	idle.AssertNumberOfCalls(t, "stop", 0)
+}
+
+func TestAcquireJobSlotReturnsReusedData(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger}
+	reused := make(chan *JobResult, 1)
+	// When
+	ds.handlerMutex.Lock()
+	ch, err := ds.acquireJobSlot(context.Background(), JobSettings{}, func() <-chan *JobResult { return reused })
+	ds.handlerMutex.Unlock()
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, (<-chan *JobResult)(reused), ch)
+}
+
+func TestAcquireJobSlotHonoursContext(t *testing.T) {
+	// Given
+	job := new(signalflowJob)
+	job.On("isRunning").Return(true)
+	job.On("isIdle").Return(false)
+	job.On("Datasource").Return("ds1")
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		maxJobs:  1,
+		handlers: []SignalFxJob{job},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// When
+	ds.handlerMutex.Lock()
+	_, err := ds.acquireJobSlot(ctx, JobSettings{Datasource: "ds1"}, nil)
+	ds.handlerMutex.Unlock()
+	// Then
+	assert.Equal(t, context.Canceled, err)
+	assert.Empty(t, ds.slotWaiters)
+}
+
+func TestAcquireJobSlotTimesOut(t *testing.T) {
+	// Given
+	job := new(signalflowJob)
+	job.On("isRunning").Return(true)
+	job.On("isIdle").Return(false)
+	job.On("Datasource").Return("ds1")
+	ds := &SignalFxDatasource{
+		logger:   datasourceHandlerTestLogger,
+		maxJobs:  1,
+		handlers: []SignalFxJob{job},
+	}
+	// When
+	ds.handlerMutex.Lock()
+	_, err := ds.acquireJobSlot(context.Background(), JobSettings{Datasource: "ds1", JobQueueTimeout: 10 * time.Millisecond}, nil)
+	ds.handlerMutex.Unlock()
+	// Then
+	assert.Equal(t, errTooManyJobs, err)
+}
+
+func TestAcquireJobSlotAfterRelease(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{
+		logger:        datasourceHandlerTestLogger,
+		maxJobs:       1,
+		transientJobs: map[string]int{"ds1": 1},
+	}
+	checked := make(chan struct{}, 1)
+	acquired := make(chan error, 1)
+	go func() {
+		ds.handlerMutex.Lock()
+		_, err := ds.acquireJobSlot(context.Background(), JobSettings{Datasource: "ds1"}, func() <-chan *JobResult {
+			select {
+			case checked <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+		ds.handlerMutex.Unlock()
+		acquired <- err
+	}()
+	// When
+	// The release waits for the mutex, which is only released once the request is queued
+	<-checked
+	ds.releaseTransientJob("ds1")
+	// Then
+	select {
+	case err := <-acquired:
+		assert.Nil(t, err)
+	case <-time.After(jobSlotPollInterval / 2):
+		t.Fatal("Queued request was not woken up")
+	}
+}
+
+func TestGlobalMaxJobsFromSettings(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{maxJobs: 100}
+	// When
+	ds.updateGlobalMaxJobs("ds1", 20)
+	ds.updateGlobalMaxJobs("ds2", 0)
+	// Then
+	assert.Equal(t, 20, ds.globalMaxJobs())
+	ds.updateGlobalMaxJobs("ds1", 200)
+	assert.Equal(t, 100, ds.globalMaxJobs())
+}
+
+func TestAcquireTransientJob(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger, maxJobs: 1}
+	settings := JobSettings{Datasource: "ds1", JobQueueTimeout: 10 * time.Millisecond}
+	// When
+	release, err := ds.acquireTransientJob(context.Background(), settings)
+	// Then
+	assert.Nil(t, err)
+	_, err = ds.acquireTransientJob(context.Background(), settings)
+	assert.Equal(t, errTooManyJobs, err)
+	release()
+	assert.Empty(t, ds.transientJobs)
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsAddressEnv enables the local metrics listener, e.g. SIGNALFX_PLUGIN_METRICS_ADDRESS=127.0.0.1:9188
+const metricsAddressEnv = "SIGNALFX_PLUGIN_METRICS_ADDRESS"
+
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	jobsMetric = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "signalfx_plugin_jobs",
+		Help: "Number of cached SignalFlow job handlers.",
+	}, []string{"datasource"})
+	jobRequestsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "signalfx_plugin_job_requests_total",
+		Help: "Requests for SignalFlow data by outcome (started, reused, coalesced, gap_fill, alerting, queued, evicted, rejected, error).",
+	}, []string{"datasource", "outcome"})
+	timeToFirstFlushMetric = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "signalfx_plugin_time_to_first_flush_seconds",
+		Help:    "Time from starting a SignalFlow job until its initial data is returned.",
+		Buckets: defaultBuckets,
+	}, []string{"datasource"})
+	apiRequestDurationMetric = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "signalfx_plugin_api_request_duration_seconds",
+		Help:    "Duration of SignalFx REST API requests.",
+		Buckets: defaultBuckets,
+	}, []string{"datasource", "path", "outcome"})
+	signalflowConnectsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "signalfx_plugin_signalflow_connects_total",
+		Help: "Number of SignalFlow computations re-established after dropped connections.",
+	}, []string{"datasource"})
+	computationErrorsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "signalfx_plugin_computation_errors_total",
+		Help: "Number of SignalFlow computations which ended with an error.",
+	}, []string{"datasource"})
+	droppedPointsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "signalfx_plugin_dropped_points_total",
+		Help: "Number of buffered datapoints dropped by the job handlers.",
+	}, []string{"datasource", "reason"})
+	computationResumesMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "signalfx_plugin_computation_resumes_total",
+		Help: "Attempts to resume SignalFlow computations after dropped connections by outcome (resumed, failed, circuit_open).",
+	}, []string{"datasource", "outcome"})
+	resultCacheRequestsMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "signalfx_plugin_result_cache_requests_total",
+		Help: "Lookups of closed time range results in the result cache by outcome (hit, miss).",
+	}, []string{"datasource", "outcome"})
+)
+
+// metricsRegistry holds the plugin metrics only, without the Go runtime collectors of the default registry
+var metricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	metricsRegistry.MustRegister(
+		jobsMetric,
+		jobRequestsMetric,
+		timeToFirstFlushMetric,
+		apiRequestDurationMetric,
+		signalflowConnectsMetric,
+		computationErrorsMetric,
+		droppedPointsMetric,
+		computationResumesMetric,
+		resultCacheRequestsMetric,
+	)
+}
+
+// metricsHandler serves all plugin metrics in the Prometheus exposition format
+var metricsHandler = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+
+func startMetricsServer(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler)
+	go func() {
+		pluginLogger.Debug("Serving metrics", "address", address)
+		if err := http.ListenAndServe(address, mux); err != nil {
+			pluginLogger.Error("Could not serve metrics", "error", err)
+		}
+	}()
+}
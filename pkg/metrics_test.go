@@ -0,0 +1,22 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	// Given
+	jobRequestsMetric.WithLabelValues("test", "started").Inc()
+	apiRequestDurationMetric.WithLabelValues("test", "/v2/metric", "success").Observe(0.5)
+	rec := httptest.NewRecorder()
+	// When
+	metricsHandler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	// Then
+	assert.Contains(t, rec.Body.String(), "signalfx_plugin_job_requests_total{datasource=\"test\",outcome=\"started\"}")
+	assert.Contains(t, rec.Body.String(), "signalfx_plugin_api_request_duration_seconds_bucket{datasource=\"test\",outcome=\"success\",path=\"/v2/metric\",le=\"1\"} 1")
+	assert.NotContains(t, rec.Body.String(), "go_goroutines")
+}
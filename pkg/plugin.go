@@ -2,6 +2,11 @@
 package main
 
 import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/grafana/grafana_plugin_model/go/datasource"
 	hclog "github.com/hashicorp/go-hclog"
 	plugin "github.com/hashicorp/go-plugin"
@@ -12,8 +17,35 @@ var pluginLogger = hclog.New(&hclog.LoggerOptions{
 	Level: hclog.LevelFromString("DEBUG"),
 })
 
+// shutdownTracingTimeout bounds exporting the remaining spans after the jobs are stopped
+const shutdownTracingTimeout = 50 * time.Millisecond
+
+// handleShutdownSignals stops the running computations and closes the clients when Grafana stops
+// or restarts the plugin, instead of leaving them to time out on the SignalFx side. plugin.Serve
+// doesn't return for gRPC plugins, so this is the only point at which the plugin can clean up.
+func handleShutdownSignals(ds *SignalFxDatasource, signals chan os.Signal, exit func(int)) {
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		pluginLogger.Debug("Received signal", "signal", sig)
+		ds.Shutdown(shutdownTimeout)
+		shutdownTracing(shutdownTracingTimeout)
+		exit(0)
+	}()
+}
+
 func main() {
 
+	signalfxDatasource := NewSignalFxDatasource()
+
+	if address := os.Getenv(metricsAddressEnv); address != "" {
+		startMetricsServer(address)
+	}
+	if err := configureTracing(); err != nil {
+		pluginLogger.Error("Could not configure tracing", "error", err)
+	}
+	handleShutdownSignals(signalfxDatasource, make(chan os.Signal, 1), os.Exit)
+
 	plugin.Serve(&plugin.ServeConfig{
 
 		HandshakeConfig: plugin.HandshakeConfig{
@@ -22,7 +54,7 @@ func main() {
 			MagicCookieValue: "datasource",
 		},
 		Plugins: map[string]plugin.Plugin{
-			"signalfx-datasource": &datasource.DatasourcePluginImpl{Plugin: NewSignalFxDatasource()},
+			"signalfx-datasource": &datasource.DatasourcePluginImpl{Plugin: signalfxDatasource},
 		},
 
 		// A non-nil value here enables gRPC serving for this plugin...
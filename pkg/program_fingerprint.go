@@ -0,0 +1,193 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	positionalLabelPattern = regexp.MustCompile(`publish\(('[^'\\]*')`)
+	publishLabelPattern    = regexp.MustCompile(`publish\(label='([^'\\]*)'`)
+	filterCallPattern      = regexp.MustCompile(`filter\('[^'\\]*'(?:,'[^'\\]*')*\)`)
+	filterChainPattern     = regexp.MustCompile(`filter\('[^'\\]*'(?:,'[^'\\]*')*\)(?:and filter\('[^'\\]*'(?:,'[^'\\]*')*\))+`)
+	stringLiteralPattern   = regexp.MustCompile(`'[^'\\]*'`)
+)
+
+// programFingerprint returns a key which is equal for SignalFlow programs producing
+// the same data, i.e. programs which differ only in whitespace, comments, quoting,
+// the order of filter() terms or the labels passed to publish()
+func programFingerprint(program string) string {
+	normalized := publishLabelPattern.ReplaceAllString(normalizeProgram(program), "publish(label=?")
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(normalized)))
+}
+
+// publishLabels returns the labels of all publish() calls in the order they appear in the program
+func publishLabels(program string) []string {
+	labels := make([]string, 0)
+	for _, m := range publishLabelPattern.FindAllStringSubmatch(normalizeProgram(program), -1) {
+		labels = append(labels, m[1])
+	}
+	return labels
+}
+
+// publishLabelMapping maps the publish() labels of a running program onto the labels
+// used by an equivalent program. It returns false if the labels can't be mapped unambiguously.
+func publishLabelMapping(from string, to string) (map[string]string, bool) {
+	fromLabels := publishLabels(from)
+	toLabels := publishLabels(to)
+	if len(fromLabels) != len(toLabels) {
+		return nil, false
+	}
+	mapping := make(map[string]string)
+	for i, label := range fromLabels {
+		if mapped, ok := mapping[label]; ok && mapped != toLabels[i] {
+			return nil, false
+		}
+		mapping[label] = toLabels[i]
+	}
+	return mapping, true
+}
+
+// normalizeProgram strips comments and insignificant whitespace from the program,
+// canonicalizes string quoting and sorts filter() terms where their order doesn't matter
+func normalizeProgram(program string) string {
+	statements := make([]string, 0)
+	var b strings.Builder
+	var literal strings.Builder
+	var last rune
+	var quote rune
+	depth := 0
+	escaped := false
+	comment := false
+	continuation := false
+	pendingSpace := false
+	write := func(r rune) {
+		if pendingSpace && isWordRune(last) && isWordRune(r) {
+			b.WriteRune(' ')
+		}
+		pendingSpace = false
+		b.WriteRune(r)
+		last = r
+	}
+	endStatement := func() {
+		if statement := b.String(); statement != "" {
+			statements = append(statements, statement)
+		}
+		b.Reset()
+		last = 0
+		pendingSpace = false
+	}
+	for _, r := range program {
+		if comment {
+			if r != '\n' {
+				continue
+			}
+			comment = false
+		}
+		if quote != 0 {
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\':
+				escaped = true
+			case r == quote:
+				// String literals never need a separating space
+				pendingSpace = false
+				b.WriteString(canonicalStringLiteral(literal.String(), quote))
+				last = quote
+				quote = 0
+				continue
+			}
+			literal.WriteRune(r)
+			continue
+		}
+		if continuation {
+			continuation = false
+			if r == '\n' {
+				pendingSpace = true
+				continue
+			}
+		}
+		switch {
+		case r == '#':
+			comment = true
+		case r == '\\':
+			continuation = true
+		case r == '\'' || r == '"':
+			quote = r
+			literal.Reset()
+		case r == '\n' && depth == 0:
+			endStatement()
+		case unicode.IsSpace(r):
+			pendingSpace = true
+		default:
+			if strings.ContainsRune("([{", r) {
+				depth++
+			} else if strings.ContainsRune(")]}", r) && depth > 0 {
+				depth--
+			}
+			write(r)
+		}
+	}
+	endStatement()
+	normalized := strings.Join(statements, "\n")
+	normalized = positionalLabelPattern.ReplaceAllString(normalized, "publish(label=$1")
+	return sortFilters(normalized)
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func canonicalStringLiteral(content string, quote rune) string {
+	if quote == '"' && !strings.ContainsAny(content, `'\`) {
+		quote = '\''
+	}
+	return string(quote) + content + string(quote)
+}
+
+// sortFilters orders the values of filter() calls and chains of filter() terms joined by
+// "and". Only calls with string literal arguments are reordered, since those are known
+// to be commutative.
+func sortFilters(program string) string {
+	program = replaceStandaloneMatches(filterCallPattern, program, func(call string) string {
+		values := stringLiteralPattern.FindAllString(call, -1)
+		sort.Strings(values[1:])
+		return "filter(" + strings.Join(values, ",") + ")"
+	})
+	return replaceStandaloneMatches(filterChainPattern, program, func(chain string) string {
+		terms := strings.Split(chain, ")and filter(")
+		for i := range terms {
+			terms[i] = "filter(" + strings.TrimSuffix(strings.TrimPrefix(terms[i], "filter("), ")") + ")"
+		}
+		sort.Strings(terms)
+		return strings.Join(terms, "and ")
+	})
+}
+
+// replaceStandaloneMatches replaces matches which are not part of a longer identifier,
+// a method call or a negation, and which are not followed by a method call or subscript
+func replaceStandaloneMatches(pattern *regexp.Regexp, program string, replace func(string) string) string {
+	var b strings.Builder
+	end := 0
+	for _, loc := range pattern.FindAllStringIndex(program, -1) {
+		before := program[:loc[0]]
+		after := program[loc[1]:]
+		if strings.HasSuffix(before, "not ") ||
+			strings.HasSuffix(before, ".") ||
+			(len(before) > 0 && isWordRune(rune(before[len(before)-1]))) ||
+			(len(after) > 0 && strings.ContainsRune(".([", rune(after[0]))) {
+			continue
+		}
+		b.WriteString(program[end:loc[0]])
+		b.WriteString(replace(program[loc[0]:loc[1]]))
+		end = loc[1]
+	}
+	b.WriteString(program[end:])
+	return b.String()
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeProgram(t *testing.T) {
+	// Given
+	program := `# CPU usage
+A = data("cpu.utilization",
+         filter=filter('host', 'b', 'a')).mean(by=['host'])  # per host
+A.publish('A')
+`
+	// When
+	normalized := normalizeProgram(program)
+	// Then
+	assert.Equal(t, "A=data('cpu.utilization',filter=filter('host','a','b')).mean(by=['host'])\nA.publish(label='A')", normalized)
+}
+
+func TestNormalizeProgramKeepsStringLiterals(t *testing.T) {
+	// Given
+	program := `data('cpu # utilization', filter=filter('name', "it's")).publish(label = 'A B')`
+	// When
+	normalized := normalizeProgram(program)
+	// Then
+	assert.Equal(t, `data('cpu # utilization',filter=filter('name',"it's")).publish(label='A B')`, normalized)
+}
+
+func TestProgramFingerprintForEquivalentPrograms(t *testing.T) {
+	// Given
+	program := "data('cpu', filter=filter('host', 'a') and filter('az', 'us-east-1')).publish(label='A')"
+	equivalent := `data("cpu",  filter=filter("az", "us-east-1") and filter("host", "a")).publish(label="B") # comment`
+	// When
+	fingerprint := programFingerprint(program)
+	equivalentFingerprint := programFingerprint(equivalent)
+	// Then
+	assert.Equal(t, fingerprint, equivalentFingerprint)
+}
+
+func TestProgramFingerprintForDifferentPrograms(t *testing.T) {
+	// Given
+	program := "data('cpu', filter=filter('host', 'a') and filter('az', 'us-east-1')).publish(label='A')"
+	negated := "data('cpu', filter=not filter('host', 'a') and filter('az', 'us-east-1')).publish(label='A')"
+	// When
+	fingerprint := programFingerprint(program)
+	negatedFingerprint := programFingerprint(negated)
+	// Then
+	assert.NotEqual(t, fingerprint, negatedFingerprint)
+}
+
+func TestPublishLabelMapping(t *testing.T) {
+	// Given
+	program := "A = data('cpu').publish(label='A')\nB = data('mem').publish('B')"
+	equivalent := "X = data('cpu').publish(label='X')\nY = data('mem').publish(label='Y')"
+	// When
+	mapping, ok := publishLabelMapping(program, equivalent)
+	// Then
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"A": "X", "B": "Y"}, mapping)
+}
+
+func TestPublishLabelMappingForAmbiguousLabels(t *testing.T) {
+	// Given
+	program := "data('cpu').publish(label='A')\ndata('mem').publish(label='A')"
+	equivalent := "data('cpu').publish(label='X')\ndata('mem').publish(label='Y')"
+	// When
+	_, ok := publishLabelMapping(program, equivalent)
+	// Then
+	assert.False(t, ok)
+}
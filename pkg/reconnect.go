@@ -0,0 +1,230 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/signalfx/signalfx-go/idtool"
+	"github.com/signalfx/signalfx-go/signalflow"
+	"github.com/signalfx/signalfx-go/signalflow/messages"
+)
+
+// Resume attempts of a computation whose connection dropped are delayed by
+// resumeInitialBackoff, doubling up to resumeMaxBackoff
+const (
+	maxResumeAttempts    = 5
+	resumeInitialBackoff = time.Second
+	resumeMaxBackoff     = 30 * time.Second
+)
+
+// The circuit breaker opens after breakerThreshold consecutive failed resumes
+// and rejects resumes for breakerCooldown
+const (
+	breakerThreshold = 5
+	breakerCooldown  = time.Minute
+)
+
+// circuitBreaker stops the jobs of a datasource from resuming while SignalFlow is unreachable,
+// so that an outage doesn't turn into a storm of execute requests. A nil breaker allows everything.
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *circuitBreaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return !now.Before(b.openUntil)
+}
+
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = 0
+}
+
+func (b *circuitBreaker) failure(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	if b.failures >= breakerThreshold {
+		b.openUntil = now.Add(breakerCooldown)
+		b.failures = 0
+	}
+}
+
+// circuitBreaker returns the breaker of the datasource. It must be called with handlerMutex held.
+func (t *SignalFxDatasource) circuitBreaker(datasource string) *circuitBreaker {
+	if t.breakers == nil {
+		t.breakers = make(map[string]*circuitBreaker)
+	}
+	breaker, ok := t.breakers[datasource]
+	if !ok {
+		breaker = &circuitBreaker{}
+		t.breakers[datasource] = breaker
+	}
+	return breaker
+}
+
+func resumeBackoff(attempt int) time.Duration {
+	backoff := resumeInitialBackoff
+	for i := 0; i < attempt && backoff < resumeMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > resumeMaxBackoff {
+		return resumeMaxBackoff
+	}
+	return backoff
+}
+
+// isConnectionError checks whether err is a dropped connection rather than an error SignalFlow
+// reported for the computation itself, such as an invalid program or token or an exceeded quota
+func isConnectionError(err error) bool {
+	var computationErr *signalflow.ComputationError
+	return err != nil && !errors.As(err, &computationErr)
+}
+
+// isResumable checks whether a computation which ended with err should be resumed.
+// Only streaming jobs whose connection dropped are resumed; stopped jobs and bounded computations are done.
+func (t *SignalFxJobHandler) isResumable(err error) bool {
+	return isConnectionError(err) && t.unbounded && !t.stopped && t.computation.Resolution() > 0
+}
+
+// resume re-executes the computation of a streaming job after its connection dropped, retrying
+// with backoff. It returns false when the job could not be resumed and has to be stopped.
+// The attempts are only reset once the resumed computation delivers data, so a computation
+// which drops again right away backs off further and counts as a failure of the breaker.
+func (t *SignalFxJobHandler) resume() bool {
+	for ; t.resumeAttempts < maxResumeAttempts; t.resumeAttempts++ {
+		attempt := t.resumeAttempts
+		if !t.breaker.allow(time.Now()) {
+			t.logger.Warn("Not resuming SignalFlow computation, too many failed attempts", "program", t.program)
+			computationResumesMetric.WithLabelValues(t.settings.Datasource, "circuit_open").Inc()
+			return false
+		}
+		t.mutex.Lock()
+		ctx := t.jobContext()
+		t.mutex.Unlock()
+		// Stopping the job cancels the backoff and the wait for a job slot
+		select {
+		case <-time.After(resumeBackoff(attempt)):
+		case <-ctx.Done():
+			return false
+		}
+		release, err := t.acquireJobSlot(ctx)
+		if err != nil {
+			t.logger.Warn("Could not resume SignalFlow computation", "program", t.program, "attempt", attempt+1, "error", err)
+			continue
+		}
+		t.mutex.Lock()
+		if t.stopped {
+			t.mutex.Unlock()
+			release()
+			return false
+		}
+		request := t.resumeRequest()
+		t.mutex.Unlock()
+		// Queries and the job limits check the state of the job, so it isn't locked while connecting
+		t.logger.Debug("Resuming job", "program", t.program, "start", request.Start)
+		comp, err := t.signalflowClient().Execute(request)
+		if err == nil {
+			t.mutex.Lock()
+			if t.stopped {
+				t.mutex.Unlock()
+				release()
+				comp.Stop()
+				return false
+			}
+			t.keepMetadata()
+			t.computation = comp
+			t.mutex.Unlock()
+			// The resumed computation counts as the running job from now on
+			release()
+			t.resumeAttempts++
+			t.logger.Info("Resumed SignalFlow computation", "program", t.program, "attempt", attempt+1)
+			computationResumesMetric.WithLabelValues(t.settings.Datasource, "resumed").Inc()
+			signalflowConnectsMetric.WithLabelValues(t.settings.Datasource).Inc()
+			return true
+		}
+		release()
+		t.breaker.failure(time.Now())
+		t.logger.Warn("Could not resume SignalFlow computation", "program", t.program, "attempt", attempt+1, "error", err)
+	}
+	computationResumesMetric.WithLabelValues(t.settings.Datasource, "failed").Inc()
+	return false
+}
+
+// resumedDataReceived resets the resume attempts once a resumed computation delivers data
+func (t *SignalFxJobHandler) resumedDataReceived() {
+	if t.resumeAttempts == 0 {
+		return
+	}
+	t.resumeAttempts = 0
+	t.breaker.success()
+}
+
+// resumeRequest starts the program again from the last received timestamp at the
+// resolution of the dropped computation. It must be called with mutex held.
+func (t *SignalFxJobHandler) resumeRequest() *signalflow.ExecuteRequest {
+	request := &signalflow.ExecuteRequest{
+		Program:    t.program,
+		Start:      t.lastTimestamp(),
+		Resolution: t.computation.Resolution(),
+	}
+	if t.maxDelay > 0 {
+		request.MaxDelayMs = t.maxDelay
+	}
+	return request
+}
+
+// signalflowClient returns the current client of the datasource, which replaces the
+// client the job was started with when the datasource settings change
+func (t *SignalFxJobHandler) signalflowClient() SignalflowClient {
+	if t.currentClient != nil {
+		if client := t.currentClient(); client != nil {
+			return client
+		}
+	}
+	return t.client
+}
+
+// lastTimestamp returns the time of the latest buffered point, or the start of the job without data
+func (t *SignalFxJobHandler) lastTimestamp() time.Time {
+	var last int64
+	for _, points := range t.Points {
+		if n := len(points); n > 0 && points[n-1].Timestamp > last {
+			last = points[n-1].Timestamp
+		}
+	}
+	if last == 0 {
+		return t.startTime
+	}
+	return time.Unix(0, last*int64(time.Millisecond))
+}
+
+// keepMetadata copies the metadata of the buffered time series from the dropped computation,
+// so that series which don't reappear in the resumed computation keep their names and tags
+func (t *SignalFxJobHandler) keepMetadata() {
+	for tsid := range t.Points {
+		meta := t.computation.TSIDMetadata(idtool.ID(tsid))
+		if meta == nil {
+			continue
+		}
+		if t.gapMetadata == nil {
+			t.gapMetadata = make(map[int64]*messages.MetadataProperties)
+		}
+		t.gapMetadata[tsid] = meta
+	}
+}
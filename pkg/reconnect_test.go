@@ -0,0 +1,252 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/signalfx/signalfx-go/idtool"
+	"github.com/signalfx/signalfx-go/signalflow"
+	"github.com/signalfx/signalfx-go/signalflow/messages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCircuitBreakerOpensAfterFailures(t *testing.T) {
+	// Given
+	breaker := &circuitBreaker{}
+	now := time.Now()
+	// When
+	for i := 0; i < breakerThreshold; i++ {
+		assert.True(t, breaker.allow(now))
+		breaker.failure(now)
+	}
+	// Then
+	assert.False(t, breaker.allow(now))
+	assert.True(t, breaker.allow(now.Add(breakerCooldown)))
+}
+
+func TestCircuitBreakerResetsOnSuccess(t *testing.T) {
+	// Given
+	breaker := &circuitBreaker{}
+	now := time.Now()
+	for i := 0; i < breakerThreshold-1; i++ {
+		breaker.failure(now)
+	}
+	// When
+	breaker.success()
+	breaker.failure(now)
+	// Then
+	assert.True(t, breaker.allow(now))
+}
+
+func TestResumeBackoff(t *testing.T) {
+	// Then
+	assert.Equal(t, time.Second, resumeBackoff(0))
+	assert.Equal(t, 4*time.Second, resumeBackoff(2))
+	assert.Equal(t, resumeMaxBackoff, resumeBackoff(10))
+}
+
+func TestIsResumable(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Resolution").Return(time.Second)
+	handler := &SignalFxJobHandler{
+		computation: computation,
+		unbounded:   true,
+	}
+	dropped := errors.New("connection closed")
+	// Then
+	assert.True(t, handler.isResumable(dropped))
+	assert.False(t, handler.isResumable(nil))
+	handler.stopped = true
+	assert.False(t, handler.isResumable(dropped))
+	handler.stopped = false
+	assert.False(t, handler.isResumable(&signalflow.ComputationError{Code: 400, Message: "invalid program"}))
+	handler.unbounded = false
+	assert.False(t, handler.isResumable(dropped))
+}
+
+func TestResumeRequestFromLastTimestamp(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Resolution").Return(10 * time.Second)
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		computation: computation,
+		program:     "some_program",
+		startTime:   time.Unix(0, 0),
+		Points: map[int64]([]*datasource.Point){
+			1: {{Timestamp: 10000}, {Timestamp: 20000}},
+			2: {{Timestamp: 10000}, {Timestamp: 30000}},
+		},
+	}
+	// When
+	request := handler.resumeRequest()
+	// Then
+	assert.Equal(t, "some_program", request.Program)
+	assert.Equal(t, int64(30000), request.Start.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, 10*time.Second, request.Resolution)
+}
+
+func TestResumeOnCurrentClient(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Resolution").Return(10 * time.Second)
+	computation.On("TSIDMetadata", mock.Anything).Return(nil)
+	closed := new(signalflowClientMock)
+	current := new(signalflowClientMock)
+	current.On("Execute", mock.Anything).Return(&signalflow.Computation{}, nil)
+	handler := &SignalFxJobHandler{
+		logger:        jobHandlerTestLogger,
+		client:        closed,
+		currentClient: func() SignalflowClient { return current },
+		computation:   computation,
+		program:       "some_program",
+		startTime:     time.Unix(0, 0),
+		Points:        make(map[int64]([]*datasource.Point)),
+		settings:      JobSettings{Datasource: "resume_test"},
+	}
+	connects := testutil.ToFloat64(signalflowConnectsMetric.WithLabelValues("resume_test"))
+	// When
+	resumed := handler.resume()
+	// Then
+	assert.True(t, resumed)
+	closed.AssertNumberOfCalls(t, "Execute", 0)
+	current.AssertNumberOfCalls(t, "Execute", 1)
+	assert.Equal(t, connects+1, testutil.ToFloat64(signalflowConnectsMetric.WithLabelValues("resume_test")))
+}
+
+func TestStopCancelsResume(t *testing.T) {
+	// Given
+	computation := new(signalflowComputationMock)
+	computation.On("Stop").Return(nil)
+	client := new(signalflowClientMock)
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		client:      client,
+		computation: computation,
+		program:     "some_program",
+	}
+	handler.jobContext()
+	handler.stop()
+	start := time.Now()
+	// When
+	resumed := handler.resume()
+	// Then
+	assert.False(t, resumed)
+	assert.True(t, time.Since(start) < resumeInitialBackoff)
+	client.AssertNumberOfCalls(t, "Execute", 0)
+}
+
+func TestResumedComputationDroppingAgainOpensBreaker(t *testing.T) {
+	// Given
+	dropped := errors.New("connection closed")
+	computation := new(signalflowComputationMock)
+	resumed := new(signalflowComputationMock)
+	for _, c := range []*signalflowComputationMock{computation, resumed} {
+		done := make(chan struct{})
+		close(done)
+		c.On("Done").Return(modifyDone(done))
+		c.On("Data").Return(modifyData(make(chan *messages.DataMessage)))
+		c.On("Info").Return((<-chan *messages.InfoMessage)(nil))
+		c.On("Expirations").Return((<-chan *messages.ExpiredTSIDMessage)(nil))
+		c.On("Resolution").Return(10 * time.Second)
+		c.On("MaxDelay").Return(time.Duration(0))
+		c.On("Err").Return(dropped)
+		c.On("Stop").Return(nil)
+	}
+	client := new(signalflowClientMock)
+	client.On("Execute", mock.Anything).Return(resumed, nil)
+	breaker := &circuitBreaker{}
+	for i := 0; i < breakerThreshold-1; i++ {
+		breaker.failure(time.Now())
+	}
+	handler := &SignalFxJobHandler{
+		logger:      jobHandlerTestLogger,
+		client:      client,
+		computation: computation,
+		program:     "some_program",
+		unbounded:   true,
+		breaker:     breaker,
+		startTime:   time.Unix(0, 0),
+		Points:      make(map[int64]([]*datasource.Point)),
+		settings:    JobSettings{Datasource: "resume_test"},
+	}
+	// When
+	handler.readDataMessages()
+	// Then
+	client.AssertNumberOfCalls(t, "Execute", 1)
+	assert.False(t, breaker.allow(time.Now()))
+	assert.True(t, handler.stopped)
+	assert.Equal(t, []string{"SignalFlow computation failed: connection closed"}, handler.warnings)
+}
+
+func TestResumedDataResetsAttempts(t *testing.T) {
+	// Given
+	breaker := &circuitBreaker{}
+	for i := 0; i < breakerThreshold-1; i++ {
+		breaker.failure(time.Now())
+	}
+	handler := &SignalFxJobHandler{
+		breaker:        breaker,
+		resumeAttempts: 2,
+	}
+	// When
+	handler.resumedDataReceived()
+	breaker.failure(time.Now())
+	// Then
+	assert.Equal(t, 0, handler.resumeAttempts)
+	assert.True(t, breaker.allow(time.Now()))
+}
+
+func TestCircuitBreakerPerDatasource(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{}
+	// Then
+	assert.True(t, ds.circuitBreaker("ds1") == ds.circuitBreaker("ds1"))
+	assert.True(t, ds.circuitBreaker("ds1") != ds.circuitBreaker("ds2"))
+}
+
+func TestAppendDataMessageSkipsRepeatedTimestamps(t *testing.T) {
+	// Given
+	points := map[int64]([]*datasource.Point){
+		123: {{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}},
+	}
+	message := &messages.DataMessage{TimestampMillis: 2000}
+	message.Payloads = []messages.DataPayload{{Type: 1, TSID: idtool.ID(123)}, {Type: 1, TSID: idtool.ID(456)}}
+	// When
+	appendDataMessage(points, message)
+	message.TimestampMillis = 3000
+	appendDataMessage(points, message)
+	// Then
+	assert.Equal(t, []int64{1000, 2000, 3000}, pointTimestamps(points[123]))
+	assert.Equal(t, []int64{2000, 3000}, pointTimestamps(points[456]))
+	assert.Equal(t, float64(2), points[123][1].Value)
+}
+
+func TestKeepMetadata(t *testing.T) {
+	// Given
+	metadata := &messages.MetadataProperties{Metric: "cpu"}
+	computation := new(signalflowComputationMock)
+	computation.On("TSIDMetadata", mock.Anything).Return(metadata)
+	handler := &SignalFxJobHandler{
+		computation: computation,
+		Points:      map[int64]([]*datasource.Point){123: {{Timestamp: 1000}}},
+	}
+	// When
+	handler.keepMetadata()
+	// Then
+	assert.Equal(t, metadata, handler.gapMetadata[123])
+}
+
+func pointTimestamps(points []*datasource.Point) []int64 {
+	timestamps := make([]int64, 0, len(points))
+	for _, p := range points {
+		timestamps = append(timestamps, p.Timestamp)
+	}
+	return timestamps
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// signalflowResolutions are the rollup resolutions supported by SignalFlow
+var signalflowResolutions = []time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	time.Hour,
+	24 * time.Hour,
+}
+
+// resolutionForBudget returns the finest SignalFlow resolution which returns at most
+// maxDataPoints points over the time range
+func resolutionForBudget(timeRange time.Duration, maxDataPoints int64) time.Duration {
+	if maxDataPoints <= 0 {
+		return 0
+	}
+	minResolution := time.Duration(math.Ceil(float64(timeRange) / float64(maxDataPoints)))
+	for _, r := range signalflowResolutions {
+		if r >= minResolution {
+			return r
+		}
+	}
+	return minResolution
+}
+
+// resolveInterval sets the resolution requested from SignalFlow for the target
+func resolveInterval(target *Target, intervalMs int64, timeRange time.Duration) error {
+	if target.MaxResolution > 0 && target.MaxResolution < target.MinResolution {
+		return fmt.Errorf("Max resolution %dms is lower than min resolution %dms", target.MaxResolution, target.MinResolution)
+	}
+	if target.ExactResolution {
+		if target.MinResolution <= 0 {
+			return fmt.Errorf("Exact resolution requires a min resolution")
+		}
+		target.Interval = time.Duration(target.MinResolution) * time.Millisecond
+		return nil
+	}
+	if intervalMs < target.MinResolution {
+		intervalMs = target.MinResolution
+	}
+	target.Interval = time.Duration(intervalMs) * time.Millisecond
+	// Ask SignalFlow for a resolution which fits the point budget of the panel
+	if resolution := resolutionForBudget(timeRange, target.MaxDataPoints); target.Interval < resolution {
+		target.Interval = resolution
+	}
+	if maxResolution := time.Duration(target.MaxResolution) * time.Millisecond; maxResolution > 0 && target.Interval > maxResolution {
+		target.Interval = maxResolution
+	}
+	return nil
+}
+
+// Alignments of the time range to the resolution
+const (
+	alignmentFloor = "floor"
+	alignmentCeil  = "ceil"
+	alignmentNone  = "none"
+)
+
+// effectiveResolution returns the SignalFlow resolution used for the requested interval
+func effectiveResolution(interval time.Duration) time.Duration {
+	for _, r := range signalflowResolutions {
+		if r >= interval {
+			return r
+		}
+	}
+	return interval
+}
+
+// alignTimeRange moves the start and stop of the target to resolution boundaries, so that
+// refreshes request the same buckets instead of partial ones at the edges of the time range.
+// The stop of time ranges which are still streaming is kept, so that they keep their newest
+// data and stay unbounded, and alerting queries evaluate the time range of the rule as it is.
+func alignTimeRange(target *Target, streamingThreshold time.Duration) {
+	if target.Alignment == "" || target.Alignment == alignmentNone || target.Interval <= 0 || target.Alerting {
+		return
+	}
+	resolution := int64(effectiveResolution(target.Interval) / time.Millisecond)
+	align := func(t time.Time) time.Time {
+		ms := t.UnixNano() / int64(time.Millisecond)
+		aligned := ms - ms%resolution
+		if target.Alignment == alignmentCeil && aligned != ms {
+			aligned += resolution
+		}
+		return time.Unix(0, aligned*int64(time.Millisecond))
+	}
+	target.StartTime = align(target.StartTime)
+	if target.StopTime.After(time.Now().Add(-streamingThreshold)) {
+		return
+	}
+	target.StopTime = align(target.StopTime)
+	// Keep at least one bucket for time ranges shorter than the resolution
+	if !target.StopTime.After(target.StartTime) {
+		target.StopTime = target.StartTime.Add(time.Duration(resolution) * time.Millisecond)
+	}
+}
+
+// checkResolution verifies the resolution picked by SignalFlow against the constraints of the target
+func checkResolution(target Target, meta ResultMeta) error {
+	resolution := time.Duration(meta.Resolution) * time.Millisecond
+	if resolution == 0 {
+		return nil
+	}
+	if target.ExactResolution && resolution != target.Interval {
+		return fmt.Errorf("SignalFlow computed the data at %s resolution instead of the exact resolution %s", resolution, target.Interval)
+	}
+	if maxResolution := time.Duration(target.MaxResolution) * time.Millisecond; maxResolution > 0 && resolution > maxResolution {
+		return fmt.Errorf("SignalFlow computed the data at %s resolution which exceeds the max resolution %s", resolution, maxResolution)
+	}
+	return nil
+}
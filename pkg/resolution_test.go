@@ -0,0 +1,127 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolutionForBudget(t *testing.T) {
+	// Given
+	day := 24 * time.Hour
+	// When
+	resolution := resolutionForBudget(day, 1000)
+	unlimited := resolutionForBudget(day, 0)
+	// Then
+	assert.Equal(t, 5*time.Minute, resolution)
+	assert.Equal(t, time.Duration(0), unlimited)
+}
+
+func TestResolveIntervalWithMaxResolution(t *testing.T) {
+	// Given
+	target := &Target{MaxDataPoints: 1000, MaxResolution: 60000}
+	// When
+	err := resolveInterval(target, 1000, 24*time.Hour)
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, time.Minute, target.Interval)
+}
+
+func TestResolveIntervalWithExactResolution(t *testing.T) {
+	// Given
+	target := &Target{MaxDataPoints: 1000, MinResolution: 10000, ExactResolution: true}
+	invalid := &Target{ExactResolution: true}
+	// When
+	err := resolveInterval(target, 60000, 24*time.Hour)
+	invalidErr := resolveInterval(invalid, 60000, 24*time.Hour)
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Second, target.Interval)
+	assert.NotNil(t, invalidErr)
+}
+
+func TestCheckResolution(t *testing.T) {
+	// Given
+	exact := Target{Interval: 10 * time.Second, ExactResolution: true}
+	bounded := Target{Interval: 10 * time.Second, MaxResolution: 60000}
+	// When
+	exactErr := checkResolution(exact, ResultMeta{Resolution: 60000})
+	boundedErr := checkResolution(bounded, ResultMeta{Resolution: 60000})
+	exceededErr := checkResolution(bounded, ResultMeta{Resolution: 300000})
+	// Then
+	assert.NotNil(t, exactErr)
+	assert.Nil(t, boundedErr)
+	assert.NotNil(t, exceededErr)
+}
+
+func TestEffectiveResolution(t *testing.T) {
+	// Then
+	assert.Equal(t, 10*time.Second, effectiveResolution(7*time.Second))
+	assert.Equal(t, time.Minute, effectiveResolution(time.Minute))
+	assert.Equal(t, 48*time.Hour, effectiveResolution(48*time.Hour))
+}
+
+func TestAlignTimeRange(t *testing.T) {
+	// Given
+	start := time.Unix(0, 1560761879121*int64(time.Millisecond))
+	stop := time.Unix(0, 1560762879121*int64(time.Millisecond))
+	floor := &Target{Interval: 7 * time.Second, Alignment: alignmentFloor, StartTime: start, StopTime: stop}
+	ceil := &Target{Interval: 7 * time.Second, Alignment: alignmentCeil, StartTime: start, StopTime: stop}
+	none := &Target{Interval: 7 * time.Second, Alignment: alignmentNone, StartTime: start, StopTime: stop}
+	// When
+	alignTimeRange(floor, defaultStreamingThresholdTimeout)
+	alignTimeRange(ceil, defaultStreamingThresholdTimeout)
+	alignTimeRange(none, defaultStreamingThresholdTimeout)
+	// Then
+	assert.Equal(t, int64(1560761870000), floor.StartTime.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, int64(1560762870000), floor.StopTime.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, int64(1560761880000), ceil.StartTime.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, int64(1560762880000), ceil.StopTime.UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, start, none.StartTime)
+	assert.Equal(t, stop, none.StopTime)
+}
+
+func TestAlignTimeRangeShorterThanResolution(t *testing.T) {
+	// Given
+	target := &Target{
+		Interval:  time.Minute,
+		Alignment: alignmentFloor,
+		StartTime: time.Unix(610, 0),
+		StopTime:  time.Unix(650, 0),
+	}
+	// When
+	alignTimeRange(target, defaultStreamingThresholdTimeout)
+	// Then
+	assert.Equal(t, time.Unix(600, 0), target.StartTime)
+	assert.Equal(t, time.Unix(660, 0), target.StopTime)
+}
+
+func TestAlignTimeRangeKeepsStreamingStop(t *testing.T) {
+	// Given
+	now := time.Now()
+	target := &Target{
+		Interval:  time.Hour,
+		Alignment: alignmentFloor,
+		StartTime: now.Add(-24 * time.Hour),
+		StopTime:  now,
+	}
+	// When
+	alignTimeRange(target, defaultStreamingThresholdTimeout)
+	// Then
+	assert.Equal(t, now, target.StopTime)
+	assert.Equal(t, now.Add(-24*time.Hour).Truncate(time.Hour), target.StartTime)
+}
+
+func TestAlignTimeRangeSkipsAlertingQueries(t *testing.T) {
+	// Given
+	start := time.Unix(610, 0)
+	stop := time.Unix(650, 0)
+	target := &Target{Interval: time.Minute, Alignment: alignmentFloor, Alerting: true, StartTime: start, StopTime: stop}
+	// When
+	alignTimeRange(target, defaultStreamingThresholdTimeout)
+	// Then
+	assert.Equal(t, start, target.StartTime)
+	assert.Equal(t, stop, target.StopTime)
+}
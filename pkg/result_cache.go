@@ -0,0 +1,153 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+)
+
+const defaultResultCacheTTL = time.Hour
+
+// resultCache keeps the results of queries over closed time ranges, whose data doesn't change anymore,
+// so that they are served without running the program again after its job was cleaned up.
+// Entries are evicted least recently used first when the cache holds more than maxPoints points.
+type resultCache struct {
+	mutex     sync.Mutex
+	owner     string
+	maxPoints int64
+	ttl       time.Duration
+	points    int64
+	entries   map[string]*list.Element
+	lru       *list.List
+}
+
+type resultCacheEntry struct {
+	key     string
+	result  *JobResult
+	points  int64
+	expires time.Time
+}
+
+func newResultCache(maxPoints int64, ttl time.Duration) *resultCache {
+	return &resultCache{
+		maxPoints: maxPoints,
+		ttl:       ttl,
+		entries:   make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// resultCacheKey identifies the result of the target by its program fingerprint and publish() labels,
+// its resolution and its time range, which is rounded to the resolution only when it was aligned
+func resultCacheKey(target Target) string {
+	start := target.StartTime.UnixNano() / int64(time.Millisecond)
+	stop := target.StopTime.UnixNano() / int64(time.Millisecond)
+	if resolution := int64(target.Interval / time.Millisecond); resolution > 0 && target.Alignment != alignmentNone {
+		start -= start % resolution
+		stop -= stop % resolution
+	}
+	return fmt.Sprintf("%s|%s|%d|%d|%d|%t|%s|%d|%d", target.Fingerprint(), strings.Join(publishLabels(target.Program), ","),
+		target.Interval/time.Millisecond, target.MaxDelay, target.MaxDataPoints, target.ExactResolution, target.Downsampling, start, stop)
+}
+
+// configure applies the datasource's cache settings
+func (c *resultCache) configure(maxPoints int64, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.maxPoints = maxPoints
+	c.ttl = ttl
+	c.evict()
+}
+
+func (c *resultCache) get(key string, now time.Time) (*JobResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*resultCacheEntry)
+	if now.After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.result, true
+}
+
+// put stores the result unless it is larger than the cache
+func (c *resultCache) put(key string, result *JobResult, now time.Time) {
+	points := int64(0)
+	for _, s := range result.Series {
+		points += int64(len(s.Points))
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if points > c.maxPoints {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	entry := &resultCacheEntry{key: key, result: result, points: points, expires: now.Add(c.ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.points += points
+	c.evict()
+}
+
+func (c *resultCache) evict() {
+	for c.points > c.maxPoints && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *resultCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*resultCacheEntry)
+	delete(c.entries, entry.key)
+	c.points -= entry.points
+}
+
+// isCacheableResult checks whether the result covers a closed time range completely
+func isCacheableResult(target Target, r *JobResult, settings JobSettings) bool {
+	return !target.Alerting &&
+		!r.Meta.Unbounded &&
+		r.Meta.Complete &&
+		target.StopTime.Before(time.Now().Add(-settings.streamingThresholdTimeout()))
+}
+
+// resultCacheOwner identifies the SignalFx organization whose data is cached by the URL and access token of the datasource
+func resultCacheOwner(info *datasource.DatasourceInfo, dsInfo *DatasourceInfo) string {
+	return fmt.Sprintf("%s|%x", info.Url, sha256.Sum256([]byte(dsInfo.AccessToken)))
+}
+
+// getResultCache returns the result cache of the datasource, or nil when it is disabled.
+// The cache is cleared when the URL or access token of the datasource change.
+func (t *SignalFxDatasource) getResultCache(info *datasource.DatasourceInfo, dsInfo *DatasourceInfo) *resultCache {
+	settings := dsInfo.jobSettings()
+	key := datasourceKey(info)
+	owner := resultCacheOwner(info, dsInfo)
+	t.handlerMutex.Lock()
+	defer t.handlerMutex.Unlock()
+	cache := t.resultCaches[key]
+	if settings.ResultCacheMaxPoints <= 0 {
+		delete(t.resultCaches, key)
+		return nil
+	}
+	if cache == nil || cache.owner != owner {
+		cache = newResultCache(settings.ResultCacheMaxPoints, settings.resultCacheTTL())
+		cache.owner = owner
+		if t.resultCaches == nil {
+			t.resultCaches = make(map[string]*resultCache)
+		}
+		t.resultCaches[key] = cache
+	} else {
+		cache.configure(settings.ResultCacheMaxPoints, settings.resultCacheTTL())
+	}
+	return cache
+}
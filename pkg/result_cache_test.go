@@ -0,0 +1,179 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResultCacheKeyAlignsTimeRange(t *testing.T) {
+	// Given
+	target := Target{
+		Program:   "data('cpu').publish(label='A')",
+		Interval:  time.Minute,
+		Alignment: alignmentFloor,
+		StartTime: time.Unix(600, 0),
+		StopTime:  time.Unix(1200, 0),
+	}
+	shifted := target
+	shifted.Program = "data( 'cpu' ).publish(label='A') # comment"
+	shifted.StartTime = time.Unix(630, 0)
+	shifted.StopTime = time.Unix(1230, 0)
+	relabeled := target
+	relabeled.Program = "data('cpu').publish(label='B')"
+	later := target
+	later.StopTime = time.Unix(1260, 0)
+	// Then
+	assert.Equal(t, resultCacheKey(target), resultCacheKey(shifted))
+	assert.NotEqual(t, resultCacheKey(target), resultCacheKey(relabeled))
+	assert.NotEqual(t, resultCacheKey(target), resultCacheKey(later))
+}
+
+func TestResultCacheKeyKeepsUnalignedTimeRange(t *testing.T) {
+	// Given
+	target := Target{
+		Program:   "data('cpu').publish(label='A')",
+		Interval:  time.Minute,
+		Alignment: alignmentNone,
+		StartTime: time.Unix(600, 0),
+		StopTime:  time.Unix(1200, 0),
+	}
+	shifted := target
+	shifted.StartTime = time.Unix(630, 0)
+	shifted.StopTime = time.Unix(1230, 0)
+	// Then
+	assert.NotEqual(t, resultCacheKey(target), resultCacheKey(shifted))
+}
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	// Given
+	result := &JobResult{Series: []*datasource.TimeSeries{{Name: "cpu", Points: makePoints(10)}}}
+	cache := newResultCache(25, time.Hour)
+	now := time.Now()
+	cache.put("a", result, now)
+	cache.put("b", result, now)
+	cache.get("a", now)
+	// When
+	cache.put("c", result, now)
+	// Then
+	_, a := cache.get("a", now)
+	_, b := cache.get("b", now)
+	_, c := cache.get("c", now)
+	assert.True(t, a)
+	assert.False(t, b)
+	assert.True(t, c)
+	assert.Equal(t, int64(20), cache.points)
+}
+
+func TestResultCacheSkipsLargeResults(t *testing.T) {
+	// Given
+	result := &JobResult{Series: []*datasource.TimeSeries{{Name: "cpu", Points: makePoints(11)}}}
+	cache := newResultCache(10, time.Hour)
+	// When
+	cache.put("a", result, time.Now())
+	// Then
+	_, ok := cache.get("a", time.Now())
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), cache.points)
+}
+
+func TestResultCacheExpires(t *testing.T) {
+	// Given
+	result := &JobResult{Series: []*datasource.TimeSeries{{Name: "cpu", Points: makePoints(10)}}}
+	cache := newResultCache(100, time.Minute)
+	now := time.Now()
+	cache.put("a", result, now)
+	// When
+	_, fresh := cache.get("a", now.Add(30*time.Second))
+	_, expired := cache.get("a", now.Add(2*time.Minute))
+	// Then
+	assert.True(t, fresh)
+	assert.False(t, expired)
+	assert.Equal(t, 0, cache.lru.Len())
+}
+
+func TestResultCacheConfigureShrinks(t *testing.T) {
+	// Given
+	result := &JobResult{Series: []*datasource.TimeSeries{{Name: "cpu", Points: makePoints(10)}}}
+	cache := newResultCache(100, time.Hour)
+	now := time.Now()
+	cache.put("a", result, now)
+	cache.put("b", result, now)
+	// When
+	cache.configure(15, time.Hour)
+	// Then
+	_, a := cache.get("a", now)
+	_, b := cache.get("b", now)
+	assert.False(t, a)
+	assert.True(t, b)
+}
+
+func TestIsCacheableResult(t *testing.T) {
+	// Given
+	settings := JobSettings{}
+	closed := Target{StopTime: time.Now().Add(-time.Hour)}
+	recent := Target{StopTime: time.Now()}
+	alerting := Target{StopTime: time.Now().Add(-time.Hour), Alerting: true}
+	result := &JobResult{Series: []*datasource.TimeSeries{{Name: "cpu", Points: makePoints(1)}}, Meta: ResultMeta{Complete: true}}
+	incomplete := &JobResult{Series: result.Series}
+	unbounded := &JobResult{Series: result.Series, Meta: ResultMeta{Complete: true, Unbounded: true}}
+	// Then
+	assert.True(t, isCacheableResult(closed, result, settings))
+	assert.False(t, isCacheableResult(recent, result, settings))
+	assert.False(t, isCacheableResult(alerting, result, settings))
+	assert.False(t, isCacheableResult(closed, incomplete, settings))
+	assert.False(t, isCacheableResult(closed, unbounded, settings))
+}
+
+func TestGetResultCache(t *testing.T) {
+	// Given
+	ds := &SignalFxDatasource{}
+	info := &datasource.DatasourceInfo{OrgId: 1, Id: 1, Url: "https://api.signalfx.com"}
+	// When
+	disabled := ds.getResultCache(info, &DatasourceInfo{})
+	cache := ds.getResultCache(info, &DatasourceInfo{ResultCacheMaxPoints: 100})
+	same := ds.getResultCache(info, &DatasourceInfo{ResultCacheMaxPoints: 50})
+	// Then
+	assert.Nil(t, disabled)
+	assert.NotNil(t, cache)
+	assert.True(t, cache == same)
+	assert.Equal(t, int64(50), cache.maxPoints)
+	assert.Equal(t, defaultResultCacheTTL, cache.ttl)
+}
+
+func TestGetResultCacheIsolatesDatasources(t *testing.T) {
+	// Given
+	result := &JobResult{Series: []*datasource.TimeSeries{{Name: "cpu", Points: makePoints(1)}}}
+	ds := &SignalFxDatasource{}
+	info := &datasource.DatasourceInfo{OrgId: 1, Id: 1, Name: "SignalFx", Url: "https://api.signalfx.com"}
+	otherOrg := &datasource.DatasourceInfo{OrgId: 2, Id: 1, Name: "SignalFx", Url: "https://api.signalfx.com"}
+	dsInfo := &DatasourceInfo{AccessToken: "a", ResultCacheMaxPoints: 100}
+	cache := ds.getResultCache(info, dsInfo)
+	cache.put("key", result, time.Now())
+	// When
+	other := ds.getResultCache(otherOrg, dsInfo)
+	rotated := ds.getResultCache(info, &DatasourceInfo{AccessToken: "b", ResultCacheMaxPoints: 100})
+	// Then
+	_, ok := other.get("key", time.Now())
+	assert.False(t, ok)
+	_, ok = rotated.get("key", time.Now())
+	assert.False(t, ok)
+}
+
+func TestFlushJobsClearsResultCache(t *testing.T) {
+	// Given
+	result := &JobResult{Series: []*datasource.TimeSeries{{Name: "cpu", Points: makePoints(1)}}}
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger}
+	info := &datasource.DatasourceInfo{OrgId: 1, Id: 1, JsonData: "{\"resultCacheMaxPoints\": 100, \"flushJobsAt\": 1000}"}
+	dsInfo, _ := ds.getDsInfo(info)
+	ds.getResultCache(info, dsInfo).put("key", result, time.Now())
+	// When
+	info.JsonData = "{\"resultCacheMaxPoints\": 100, \"flushJobsAt\": 2000}"
+	dsInfo, _ = ds.getDsInfo(info)
+	// Then
+	_, ok := ds.getResultCache(info, dsInfo).get("key", time.Now())
+	assert.False(t, ok)
+}
@@ -0,0 +1,176 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+)
+
+// Result formats of SignalFlow targets
+const (
+	formatTimeSeries = "time_series"
+	formatTable      = "table"
+	formatInstant    = "instant"
+)
+
+// Reducers aggregating the values of a time series in table format
+const (
+	reducerLast = "last"
+	reducerMean = "mean"
+	reducerMin  = "min"
+	reducerMax  = "max"
+	reducerSum  = "sum"
+)
+
+func validateTarget(target *Target) error {
+	switch target.Format {
+	case "":
+		target.Format = formatTimeSeries
+	case formatTimeSeries, formatTable, formatInstant:
+	default:
+		return fmt.Errorf("Unsupported format %q", target.Format)
+	}
+	switch target.Reducer {
+	case "":
+		target.Reducer = reducerLast
+	case reducerLast, reducerMean, reducerMin, reducerMax, reducerSum:
+	default:
+		return fmt.Errorf("Unsupported reducer %q", target.Reducer)
+	}
+	switch target.Downsampling {
+	case "":
+		target.Downsampling = downsamplingLTTB
+	case downsamplingLTTB, downsamplingAverage:
+	default:
+		return fmt.Errorf("Unsupported downsampling %q", target.Downsampling)
+	}
+	switch target.Alignment {
+	case "":
+		target.Alignment = alignmentNone
+	case alignmentFloor, alignmentCeil, alignmentNone:
+	default:
+		return fmt.Errorf("Unsupported alignment %q", target.Alignment)
+	}
+	return nil
+}
+
+// seriesTable returns one row per time series with its metric, label and dimensions.
+// Instant queries report the latest point, table queries the reduced value over the time range.
+func seriesTable(target Target, series []*datasource.TimeSeries) *TableBuilder {
+	start := target.StartTime.UnixNano() / int64(time.Millisecond)
+	stop := target.StopTime.UnixNano() / int64(time.Millisecond)
+	dimensionSet := make(map[string]bool)
+	for _, s := range series {
+		for k := range s.Tags {
+			if !strings.HasPrefix(k, "sf_") {
+				dimensionSet[k] = true
+			}
+		}
+	}
+	dimensions := sortedSet(dimensionSet)
+
+	columns := make([]TableColumn, 0, len(dimensions)+4)
+	if target.Format == formatInstant {
+		columns = append(columns, TableColumn{Name: "time", Type: timeColumn})
+	}
+	columns = append(columns, TableColumn{Name: "metric", Type: stringColumn}, TableColumn{Name: "label", Type: stringColumn})
+	for _, d := range dimensions {
+		columns = append(columns, TableColumn{Name: d, Type: stringColumn})
+	}
+	columns = append(columns, TableColumn{Name: "value", Type: numberColumn})
+
+	type seriesRow struct {
+		key    string
+		values []interface{}
+	}
+	rows := make([]seriesRow, 0, len(series))
+	for _, s := range series {
+		points := pointsInRange(s.Points, start, stop)
+		if len(points) == 0 {
+			continue
+		}
+		var timestamp int64
+		var value float64
+		if target.Format == formatInstant {
+			last := points[len(points)-1]
+			timestamp, value = last.Timestamp, last.Value
+		} else {
+			value = reducePoints(target.Reducer, points)
+		}
+		labels := []string{s.Name, decodeTagValue(s.Tags["sf_streamLabel"])}
+		for _, d := range dimensions {
+			labels = append(labels, decodeTagValue(s.Tags[d]))
+		}
+		values := make([]interface{}, 0, len(columns))
+		if target.Format == formatInstant {
+			values = append(values, timestamp)
+		}
+		for _, l := range labels {
+			values = append(values, l)
+		}
+		rows = append(rows, seriesRow{key: strings.Join(labels, "\xff"), values: append(values, value)})
+	}
+	// Keep the row order stable between refreshes
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].key < rows[j].key
+	})
+
+	table := NewTableBuilder(columns...)
+	for _, row := range rows {
+		table.AddRow(row.values...)
+	}
+	return table
+}
+
+// pointsInRange returns the points of the time range, skipping the points kept before it and null values
+func pointsInRange(points []*datasource.Point, start int64, stop int64) []*datasource.Point {
+	result := make([]*datasource.Point, 0, len(points))
+	for _, p := range points {
+		if p.Timestamp >= start && p.Timestamp <= stop && !math.IsNaN(p.Value) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func reducePoints(reducer string, points []*datasource.Point) float64 {
+	value := points[0].Value
+	if reducer == reducerLast {
+		return points[len(points)-1].Value
+	}
+	for _, p := range points[1:] {
+		switch reducer {
+		case reducerMin:
+			value = math.Min(value, p.Value)
+		case reducerMax:
+			value = math.Max(value, p.Value)
+		case reducerMean, reducerSum:
+			value += p.Value
+		}
+	}
+	if reducer == reducerMean {
+		value /= float64(len(points))
+	}
+	return value
+}
+
+// decodeTagValue decodes the JSON encoded tag values of the time series
+func decodeTagValue(encoded string) string {
+	if encoded == "" {
+		return ""
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(encoded), &value); err != nil {
+		return encoded
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,101 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesTable(t *testing.T) {
+	// Given
+	target := Target{
+		Format:    formatTable,
+		Reducer:   reducerMean,
+		StartTime: time.Unix(0, 1000*int64(time.Millisecond)),
+		StopTime:  time.Unix(0, 3000*int64(time.Millisecond)),
+	}
+	series := []*datasource.TimeSeries{
+		{
+			Name: "cpu.utilization",
+			Tags: map[string]string{"host": "\"b\"", "sf_streamLabel": "\"A\""},
+			Points: []*datasource.Point{
+				{Timestamp: 0, Value: 100},
+				{Timestamp: 1000, Value: 1},
+				{Timestamp: 2000, Value: 2},
+				{Timestamp: 3000, Value: 6},
+			},
+		},
+		{
+			Name:   "cpu.utilization",
+			Tags:   map[string]string{"host": "\"a\"", "az": "\"us-east-1\"", "sf_streamLabel": "\"A\""},
+			Points: []*datasource.Point{{Timestamp: 2000, Value: 5}},
+		},
+	}
+	// When
+	table := seriesTable(target, series).Table()
+	// Then
+	columns := make([]string, 0)
+	for _, c := range table.Columns {
+		columns = append(columns, c.Name)
+	}
+	assert.Equal(t, []string{"metric", "label", "az", "host", "value"}, columns)
+	assert.Equal(t, 2, len(table.Rows))
+	assert.Equal(t, "us-east-1", table.Rows[0].Values[2].StringValue)
+	assert.Equal(t, 5.0, table.Rows[0].Values[4].DoubleValue)
+	assert.Equal(t, "b", table.Rows[1].Values[3].StringValue)
+	assert.Equal(t, 3.0, table.Rows[1].Values[4].DoubleValue)
+}
+
+func TestSeriesTableInstant(t *testing.T) {
+	// Given
+	target := Target{
+		Format:    formatInstant,
+		StartTime: time.Unix(0, 1000*int64(time.Millisecond)),
+		StopTime:  time.Unix(0, 3000*int64(time.Millisecond)),
+	}
+	series := []*datasource.TimeSeries{
+		{
+			Name: "cpu.utilization",
+			Tags: map[string]string{"host": "\"b\"", "sf_streamLabel": "\"A\""},
+			Points: []*datasource.Point{
+				{Timestamp: 0, Value: 100},
+				{Timestamp: 1000, Value: 1},
+				{Timestamp: 2000, Value: 2},
+				{Timestamp: 3000, Value: 6},
+			},
+		},
+		{
+			Name:   "cpu.utilization",
+			Tags:   map[string]string{"host": "\"a\"", "az": "\"us-east-1\"", "sf_streamLabel": "\"A\""},
+			Points: []*datasource.Point{{Timestamp: 2000, Value: 5}},
+		},
+	}
+	// When
+	table := seriesTable(target, series).Table()
+	// Then
+	assert.Equal(t, "time", table.Columns[0].Name)
+	assert.Equal(t, int64(3000), table.Rows[1].Values[0].Int64Value)
+	assert.Equal(t, 6.0, table.Rows[1].Values[5].DoubleValue)
+}
+
+func TestValidateTarget(t *testing.T) {
+	// Given
+	target := &Target{}
+	invalid := &Target{Format: "heatmap"}
+	invalidAlignment := &Target{Alignment: "round"}
+	// When
+	err := validateTarget(target)
+	invalidErr := validateTarget(invalid)
+	invalidAlignmentErr := validateTarget(invalidAlignment)
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, formatTimeSeries, target.Format)
+	assert.Equal(t, reducerLast, target.Reducer)
+	assert.Equal(t, downsamplingLTTB, target.Downsampling)
+	assert.Equal(t, alignmentNone, target.Alignment)
+	assert.NotNil(t, invalidErr)
+	assert.NotNil(t, invalidAlignmentErr)
+}
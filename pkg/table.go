@@ -0,0 +1,116 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+)
+
+// defaultTableRefID is used for queries without a RefID, e.g. variable queries from the frontend
+const defaultTableRefID = "items"
+
+type columnType int
+
+const (
+	stringColumn columnType = iota
+	numberColumn
+	timeColumn
+	boolColumn
+)
+
+type TableColumn struct {
+	Name string
+	Type columnType
+}
+
+// TableBuilder builds a Grafana table whose row values are converted according to the column types
+type TableBuilder struct {
+	columns []TableColumn
+	table   *datasource.Table
+}
+
+func NewTableBuilder(columns ...TableColumn) *TableBuilder {
+	b := &TableBuilder{
+		columns: columns,
+		table: &datasource.Table{
+			Columns: make([]*datasource.TableColumn, 0, len(columns)),
+			Rows:    make([]*datasource.TableRow, 0),
+		},
+	}
+	for _, c := range columns {
+		b.table.Columns = append(b.table.Columns, &datasource.TableColumn{Name: c.Name})
+	}
+	return b
+}
+
+// AddRow appends a row with one value per column. Missing values are null.
+func (b *TableBuilder) AddRow(values ...interface{}) {
+	row := &datasource.TableRow{Values: make([]*datasource.RowValue, 0, len(b.columns))}
+	for i, c := range b.columns {
+		var v interface{}
+		if i < len(values) {
+			v = values[i]
+		}
+		row.Values = append(row.Values, rowValue(c.Type, v))
+	}
+	b.table.Rows = append(b.table.Rows, row)
+}
+
+func (b *TableBuilder) Table() *datasource.Table {
+	return b.table
+}
+
+// Response returns the table as the result of the query with the given RefID
+func (b *TableBuilder) Response(refID string) *datasource.DatasourceResponse {
+	if refID == "" {
+		refID = defaultTableRefID
+	}
+	return &datasource.DatasourceResponse{
+		Results: []*datasource.QueryResult{
+			{
+				RefId:  refID,
+				Tables: []*datasource.Table{b.table},
+			},
+		},
+	}
+}
+
+func rowValue(t columnType, v interface{}) *datasource.RowValue {
+	if v == nil {
+		return &datasource.RowValue{Kind: datasource.RowValue_TYPE_NULL}
+	}
+	switch t {
+	case numberColumn:
+		switch n := v.(type) {
+		case int:
+			return &datasource.RowValue{Kind: datasource.RowValue_TYPE_INT64, Int64Value: int64(n)}
+		case int64:
+			return &datasource.RowValue{Kind: datasource.RowValue_TYPE_INT64, Int64Value: n}
+		case float64:
+			return &datasource.RowValue{Kind: datasource.RowValue_TYPE_DOUBLE, DoubleValue: n}
+		}
+	case timeColumn:
+		// Times are epoch milliseconds, zero means unset
+		switch ts := v.(type) {
+		case int64:
+			if ts != 0 {
+				return &datasource.RowValue{Kind: datasource.RowValue_TYPE_INT64, Int64Value: ts}
+			}
+		case time.Time:
+			if !ts.IsZero() {
+				return &datasource.RowValue{Kind: datasource.RowValue_TYPE_INT64, Int64Value: ts.UnixNano() / int64(time.Millisecond)}
+			}
+		}
+		return &datasource.RowValue{Kind: datasource.RowValue_TYPE_NULL}
+	case boolColumn:
+		if b, ok := v.(bool); ok {
+			return &datasource.RowValue{Kind: datasource.RowValue_TYPE_BOOL, BoolValue: b}
+		}
+	}
+	if s, ok := v.(string); ok {
+		return &datasource.RowValue{Kind: datasource.RowValue_TYPE_STRING, StringValue: s}
+	}
+	return &datasource.RowValue{Kind: datasource.RowValue_TYPE_STRING, StringValue: fmt.Sprint(v)}
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana_plugin_model/go/datasource"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestTableBuilder(t *testing.T) {
+	// Given
+	table := NewTableBuilder(
+		TableColumn{Name: "name", Type: stringColumn},
+		TableColumn{Name: "count", Type: numberColumn},
+		TableColumn{Name: "ratio", Type: numberColumn},
+		TableColumn{Name: "updated", Type: timeColumn},
+		TableColumn{Name: "created", Type: timeColumn})
+	// When
+	table.AddRow("cpu", 3, 0.5, time.Unix(1560761879, 0))
+	response := table.Response("B")
+	// Then
+	assert.Equal(t, "B", response.Results[0].RefId)
+	row := response.Results[0].Tables[0].Rows[0]
+	assert.Equal(t, datasource.RowValue_TYPE_STRING, row.Values[0].Kind)
+	assert.Equal(t, int64(3), row.Values[1].Int64Value)
+	assert.Equal(t, 0.5, row.Values[2].DoubleValue)
+	assert.Equal(t, int64(1560761879000), row.Values[3].Int64Value)
+	assert.Equal(t, datasource.RowValue_TYPE_NULL, row.Values[4].Kind)
+}
+
+func TestTableBuilderDefaultRefID(t *testing.T) {
+	// Given
+	table := NewTableBuilder(TableColumn{Name: "name", Type: stringColumn})
+	// When
+	response := table.Response("")
+	// Then
+	assert.Equal(t, "items", response.Results[0].RefId)
+}
+
+func TestGetMetrics(t *testing.T) {
+	// Given
+	server := newAPITestServer(map[string]string{
+		"/v2/metric?query=name%3Acpu%2A": `{"count": 1, "results": [{"name": "cpu.utilization", "description": "CPU", "type": "GAUGE", "lastUpdated": 1560761879121}]}`,
+	})
+	defer server.Close()
+	ds := &SignalFxDatasource{logger: datasourceHandlerTestLogger, apiClient: NewSignalFxApiClient(datasourceHandlerTestLogger)}
+	req := newAPITestRequest(server.URL, `{"path": "/v2/metric", "query": "query=name%3Acpu%2A"}`)
+	req.Queries[0].RefId = "A"
+	// When
+	response, err := ds.runQuery(context.Background(), req)
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, "A", response.Results[0].RefId)
+	table := response.Results[0].Tables[0]
+	assert.Equal(t, 4, len(table.Columns))
+	assert.Equal(t, []interface{}{"cpu.utilization", "CPU", "GAUGE", int64(1560761879121)},
+		[]interface{}{table.Rows[0].Values[0].StringValue, table.Rows[0].Values[1].StringValue, table.Rows[0].Values[2].StringValue, table.Rows[0].Values[3].Int64Value})
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/net/context"
+)
+
+// Tracing is configured with the standard OpenTelemetry environment variables:
+// OTEL_TRACES_EXPORTER selects otlp, console (written to stderr) or none, and the OTLP/HTTP
+// exporter reads its endpoint and headers from the OTEL_EXPORTER_OTLP_* variables.
+// SIGNALFX_PLUGIN_TRACES_FILE writes spans to a file instead, for local testing.
+const (
+	tracesExporterEnv = "OTEL_TRACES_EXPORTER"
+	tracesFileEnv     = "SIGNALFX_PLUGIN_TRACES_FILE"
+	tracerName        = "signalfx-datasource"
+)
+
+var (
+	// tracerProvider exports the spans, it is nil when tracing is not configured
+	tracerProvider *sdktrace.TracerProvider
+	pluginTracer   trace.Tracer = noop.NewTracerProvider().Tracer(tracerName)
+)
+
+// startSpan starts a span which is a child of the span in the context, if any.
+// Query spans are root spans: the legacy plugin transport doesn't pass a trace context from Grafana.
+func startSpan(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return pluginTracer.Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// recordSpanError records the error and marks the span as failed
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// configureTracing sets up the span exporter from the environment. Spans are
+// not recorded when tracing is not configured.
+func configureTracing() error {
+	var exporter sdktrace.SpanExporter
+	var err error
+	if path := os.Getenv(tracesFileEnv); path != "" {
+		f, openErr := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if openErr != nil {
+			return openErr
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	} else {
+		switch os.Getenv(tracesExporterEnv) {
+		case "otlp":
+			exporter, err = otlptracehttp.New(context.Background())
+		case "console":
+			// Stdout is reserved for the plugin handshake
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		case "", "none":
+			return nil
+		default:
+			return fmt.Errorf("Unsupported traces exporter %q", os.Getenv(tracesExporterEnv))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	setTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracerName))),
+	))
+	return nil
+}
+
+func setTracerProvider(provider *sdktrace.TracerProvider) {
+	tracerProvider = provider
+	pluginTracer = provider.Tracer(tracerName)
+}
+
+// shutdownTracing exports the queued spans, waiting at most until the timeout
+func shutdownTracing(timeout time.Duration) {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		pluginLogger.Warn("Could not export spans", "error", err)
+	}
+}
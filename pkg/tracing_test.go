@@ -0,0 +1,70 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+// recordSpans records the spans started by the plugin until the returned function is called
+func recordSpans() (*tracetest.SpanRecorder, func()) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := pluginTracer
+	setTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder, func() {
+		tracerProvider = nil
+		pluginTracer = previous
+	}
+}
+
+func TestStartSpanParentsChildSpans(t *testing.T) {
+	// Given
+	recorder, restore := recordSpans()
+	defer restore()
+	// When
+	ctx, parent := startSpan(context.Background(), "Query", trace.SpanKindServer)
+	_, child := startSpan(ctx, "doRequest", trace.SpanKindClient)
+	child.End()
+	parent.End()
+	// Then
+	spans := recorder.Ended()
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "doRequest", spans[0].Name())
+	assert.Equal(t, trace.SpanKindClient, spans[0].SpanKind())
+	assert.Equal(t, spans[1].SpanContext().TraceID(), spans[0].SpanContext().TraceID())
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.False(t, spans[1].Parent().IsValid())
+}
+
+func TestRecordSpanError(t *testing.T) {
+	// Given
+	recorder, restore := recordSpans()
+	defer restore()
+	_, span := startSpan(context.Background(), "Query", trace.SpanKindServer)
+	span.SetAttributes(attribute.String("refId", "A"))
+	// When
+	recordSpanError(span, errors.New("failed"))
+	span.End()
+	// Then
+	spans := recorder.Ended()
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "failed", spans[0].Status().Description)
+	assert.Equal(t, "exception", spans[0].Events()[0].Name)
+	assert.Equal(t, []attribute.KeyValue{attribute.String("refId", "A")}, spans[0].Attributes())
+}
+
+func TestSpansAreNotRecordedWithoutTracing(t *testing.T) {
+	// When
+	_, span := startSpan(context.Background(), "Query", trace.SpanKindServer)
+	// Then
+	assert.False(t, span.IsRecording())
+}
@@ -0,0 +1,10 @@
+// Copyright (C) 2019-2020 Splunk, Inc. All rights reserved.
+
+class SignalFxAnnotationsQueryCtrl {
+    constructor() {
+        this.annotation.source = this.annotation.source || 'events';
+    }
+}
+
+SignalFxAnnotationsQueryCtrl.templateUrl = 'partials/annotations.editor.html';
+export { SignalFxAnnotationsQueryCtrl };
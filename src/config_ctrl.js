@@ -12,6 +12,10 @@ export class SignalFxConfigCtrl {
         this.current.secureJsonFields.accessToken = false;
         this.current.secureJsonData = this.current.secureJsonData || {};
     }
+    onFlushJobs($event) {
+        $event.preventDefault();
+        this.current.jsonData.flushJobsAt = Date.now();
+    }
     onAccessChange() {
         if (this.current.access === 'proxy') {
             this.current.jsonData.accessToken = null;
@@ -30,17 +30,101 @@ export class SignalFxDatasource {
             });
         }
         this.streams = [];
+        this.tablePaths = {
+            detectors: '/v2/detector',
+            incidents: '/v2/incident',
+            dimensions: '/v2/dimension',
+            jobs: '/_plugin/jobs',
+        };
         // give interpolateQueryStr access to this
         this.interpolateQueryStr = this.interpolateQueryStr.bind(this);
     }
 
     query(options) {
+        const tableTargets = _.filter(options.targets, t => t.hide !== true && (this.isTableQuery(t) || this.isSignalflowTable(t)));
+        if (tableTargets.length > 0) {
+            const signalflowOptions = _.clone(options);
+            signalflowOptions.targets = _.difference(options.targets, tableTargets);
+            const tableQueries = _.map(tableTargets, t => this.isTableQuery(t) ? this.queryTable(t, options) : this.querySignalflowTable(t, options));
+            return Promise.all([this.querySignalflow(signalflowOptions)].concat(tableQueries))
+                .then(results => ({ data: _.flatMap(results, r => r.data) }));
+        }
+        return this.querySignalflow(options);
+    }
+
+    isTableQuery(target) {
+        return _.has(this.tablePaths, target.queryType);
+    }
+
+    isSignalflowTable(target) {
+        return this.proxyAccess && !this.isTableQuery(target) && (target.format === 'table' || target.format === 'instant');
+    }
+
+    queryTable(target, options) {
+        const path = this.tablePaths[target.queryType];
+        const params = {};
+        if (target.dimensionQuery) {
+            params.query = this.templateSrv.replace(target.dimensionQuery, options.scopedVars);
+        }
+        if (target.name) {
+            params.name = this.templateSrv.replace(target.name, options.scopedVars);
+        }
+        if (target.tags) {
+            params.tags = this.templateSrv.replace(target.tags, options.scopedVars);
+        }
+        return this.doRequest({ url: path, params, method: 'GET', refId: target.refId, raw: true })
+            .then(response => this.mapTableResponse(target, response));
+    }
+
+    querySignalflowTable(target, options) {
+        return this.backendSrv.datasourceRequest({
+            url: '/api/tsdb/query',
+            method: 'POST',
+            data: {
+                from: options.range.from.valueOf().toString(),
+                to: options.range.to.valueOf().toString(),
+                queries: [{
+                    refId: target.refId,
+                    datasourceId: this.datasourceId,
+                    program: this.templateSrv.replace(target.program, options.scopedVars, this.interpolateQueryStr),
+                    intervalMs: Math.max(options.intervalMs, target.minResolution || 0),
+                    maxDelay: target.maxDelay || 0,
+                    maxDataPoints: options.maxDataPoints,
+                    keepStreaming: target.keepStreaming,
+                    format: target.format,
+                    reducer: target.reducer,
+                    maxResolution: target.maxResolution || 0,
+                    exactResolution: target.exactResolution,
+                    alignment: target.alignment,
+                }]
+            }
+        }).then(response => this.mapTableResponse(target, response));
+    }
+
+    mapTableResponse(target, response) {
+        const table = response.data.results[target.refId].tables[0];
+        return {
+            data: [{
+                type: 'table',
+                refId: target.refId,
+                columns: _.map(table.columns, c => ({ text: c.name })),
+                rows: table.rows,
+            }]
+        };
+    }
+
+    querySignalflow(options) {
         const queries = _.filter(options.targets, t => { return t.hide !== true; })
             .map(t => this.templateSrv.replace(t.program, options.scopedVars, this.interpolateQueryStr));
         const program = queries.join('\n');
 
         const mutableOptions = _.clone(options)
         mutableOptions.intervalMs = this.getMinResolution(options);
+        mutableOptions.keepStreaming = _.some(options.targets, t => t.hide !== true && t.keepStreaming);
+        mutableOptions.maxResolution = this.getMaxResolution(options);
+        mutableOptions.exactResolution = _.some(options.targets, t => t.hide !== true && t.exactResolution);
+        mutableOptions.downsampling = _.get(_.find(options.targets, t => t.hide !== true && t.downsampling), 'downsampling');
+        mutableOptions.alignment = _.get(_.find(options.targets, t => t.hide !== true && t.alignment), 'alignment');
         const aliases = this.collectAliases(options);
         const maxDelay = this.getMaxDelay(options);
 
@@ -84,6 +168,11 @@ export class SignalFxDatasource {
         return Math.max(options.intervalMs, minResolution);
     }
 
+    getMaxResolution(options) {
+        const maxResolutions = _.filter(_.map(options.targets, t => t.maxResolution), r => r > 0);
+        return maxResolutions.length > 0 ? _.min(maxResolutions) : 0;
+    }
+
     getSignalflowHandler(options) {
         if (this.proxyAccess) {
             return new ProxyHandler(this.datasourceId, this.backendSrv, this.templateSrv);
@@ -96,6 +185,52 @@ export class SignalFxDatasource {
         return handler;
     }
 
+    annotationQuery(options) {
+        const annotation = options.annotation;
+        if (!this.proxyAccess) {
+            return Promise.resolve([]);
+        }
+        const query = {
+            refId: 'annotations',
+            datasourceId: this.datasourceId,
+            annotation: true,
+        };
+        if (annotation.source === 'program') {
+            query.program = this.templateSrv.replace(annotation.program, {}, this.interpolateQueryStr);
+        } else if (annotation.source === 'incidents') {
+            query.path = '/v2/incident';
+            query.query = annotation.detectorName ? 'detectorName=' + encodeURIComponent(this.templateSrv.replace(annotation.detectorName)) : '';
+        } else {
+            query.path = '/v2/event/find';
+            query.query = annotation.query ? 'query=' + encodeURIComponent(this.templateSrv.replace(annotation.query)) : '';
+        }
+        return this.backendSrv.datasourceRequest({
+            url: '/api/tsdb/query',
+            method: 'POST',
+            data: {
+                from: options.range.from.valueOf().toString(),
+                to: options.range.to.valueOf().toString(),
+                queries: [query]
+            }
+        }).then(response => this.mapAnnotationsResponse(annotation, response));
+    }
+
+    mapAnnotationsResponse(annotation, response) {
+        const table = response.data.results.annotations.tables[0];
+        return _.map(table.rows, row => {
+            const timeEnd = row[1];
+            return {
+                annotation,
+                time: row[0],
+                timeEnd: timeEnd ? timeEnd : undefined,
+                isRegion: !!timeEnd,
+                title: row[2],
+                text: row[3],
+                tags: row[4] ? JSON.parse(row[4]) : [],
+            };
+        });
+    }
+
     testDatasource() {
         return this.doRequest({
             url: '/v2/metric',
@@ -212,6 +347,7 @@ export class SignalFxDatasource {
     doBackendProxyRequest(options) {
         options.data = {
             queries: [{
+                refId: options.refId || 'items',
                 datasourceId: this.datasourceId,
                 path: options.url,
                 query: _.toPairs(options.params).map(p => encodeURIComponent(p[0]) + '=' + encodeURIComponent(p[1])).join('&'),
@@ -220,6 +356,9 @@ export class SignalFxDatasource {
         };
         options.url = '/api/tsdb/query';
         options.method = 'POST';
+        if (options.raw) {
+            return this.backendSrv.datasourceRequest(options);
+        }
         return this.backendSrv.datasourceRequest(options).then(this.mapBackendProxyResponse);
     }
 
@@ -2,9 +2,11 @@
 import { SignalFxDatasource } from './datasource';
 import { SignalFxQueryCtrl } from './query_ctrl';
 import { SignalFxConfigCtrl } from './config_ctrl';
+import { SignalFxAnnotationsQueryCtrl } from './annotations_query_ctrl';
 
 export {
     SignalFxDatasource as Datasource,
     SignalFxQueryCtrl as QueryCtrl,
-    SignalFxConfigCtrl as ConfigCtrl
+    SignalFxConfigCtrl as ConfigCtrl,
+    SignalFxAnnotationsQueryCtrl as AnnotationsQueryCtrl
 };
@@ -27,6 +27,11 @@ export class ProxyHandler {
                             intervalMs: options.intervalMs,
                             maxDelay,
                             maxDataPoints: options.maxDataPoints,
+                            keepStreaming: options.keepStreaming,
+                            downsampling: options.downsampling,
+                            alignment: options.alignment,
+                            maxResolution: options.maxResolution,
+                            exactResolution: options.exactResolution,
                             datasourceId: this.datasourceId,
                             program,
                         }]
@@ -37,7 +42,7 @@ export class ProxyHandler {
                     const r = response.data.results[refId];
                     _.forEach(r.series, s => {
                         const nameId = this.tagProcessor.timeSeriesNameAndId(s.name, this.unmarshallTags(s.tags), this.aliases);
-                        seriesList.push({ target: nameId.name, datapoints: s.points, id: nameId.id });
+                        seriesList.push({ target: nameId.name, datapoints: s.points, id: nameId.id, meta: r.meta });
                     });
                     seriesList.sort((a, b) => a.id.localeCompare(b.id));
                     const data = {
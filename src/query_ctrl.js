@@ -6,9 +6,42 @@ class SignalFxQueryCtrl extends QueryCtrl {
     constructor($scope, $injector) {
         super($scope, $injector);
         this.lastError = null;
+        this.target.queryType = this.target.queryType || 'signalflow';
+        this.queryTypes = [
+            { text: 'SignalFlow', value: 'signalflow' },
+            { text: 'Detectors', value: 'detectors' },
+            { text: 'Active incidents', value: 'incidents' },
+            { text: 'Dimensions', value: 'dimensions' },
+        ];
+        if (this.datasource.proxyAccess) {
+            this.queryTypes.push({ text: 'Plugin jobs', value: 'jobs' });
+        }
+        this.formats = [
+            { text: 'Time series', value: 'time_series' },
+            { text: 'Table', value: 'table' },
+            { text: 'Instant', value: 'instant' },
+        ];
+        this.reducers = ['last', 'mean', 'min', 'max', 'sum'];
+        this.downsamplings = [
+            { text: 'LTTB', value: 'lttb' },
+            { text: 'Average', value: 'average' },
+        ];
+        this.alignments = [
+            { text: 'None', value: 'none' },
+            { text: 'Floor', value: 'floor' },
+            { text: 'Ceil', value: 'ceil' },
+        ];
         this.panelCtrl.events.on('data-received', this.onDataReceived.bind(this), $scope);
         this.panelCtrl.events.on('data-error', this.onDataError.bind(this), $scope);
     }
+    isTableQuery() {
+        return this.datasource.isTableQuery(this.target);
+    }
+
+    isDetectorQuery() {
+        return this.target.queryType === 'detectors' || this.target.queryType === 'incidents';
+    }
+
     onDataReceived(dataList) {
         this.lastError = null;
     }